@@ -0,0 +1,254 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The sorted column names of the bookkeeping tables, which tell another
+// project's bookkeeping tables in the same database apart from user tables.
+var BOOKKEEPING_TABLE_COLUMNS = []string{
+  "created,file_hash,file_name",
+  "created,file_hash,file_name,schema_name",
+  "created,id,stmt,stmt_hash,stmt_name,stmt_type,updated",
+}
+
+// Lists BOOKKEEPING_TABLE_COLUMNS as SQL strings.
+func bookkeepingColumnsSql() string {
+  return "'" + strings.Join(BOOKKEEPING_TABLE_COLUMNS, "', '") + "'"
+}
+
+// The first postgres version with every catalog column the queries read.
+const POSTGRES_CATALOG_MIN_VERSION = 130000
+
+// Schemas holding the objects of the database being migrated, aliased n. $1
+// is the bookkeeping schema, which is left out as well.
+const POSTGRES_USER_SCHEMA = `n.nspname not in ('pg_catalog', 'information_schema', 'pg_toast')
+  and n.nspname not like 'pg_temp_%' and n.nspname not like 'pg_toast_temp_%' and n.nspname <> $1`
+
+// Objects created by an extension come with it, so they're left out.
+func postgresNotInExtension(catalog string, oid string) string {
+  return fmt.Sprintf("not exists (select from pg_depend d where d.classid = '%s'::regclass and d.objid = %s and d.deptype = 'e')", catalog, oid)
+}
+
+// Leaves out the relation with the given oid when it's a bookkeeping table.
+func postgresNotBookkeeping(oid string) string {
+  return fmt.Sprintf(`coalesce((
+    select string_agg(a.attname::text, ',' order by a.attname) from pg_attribute a where a.attrelid = %s and a.attnum > 0 and not a.attisdropped
+  ), '') not in (%s)`, oid, bookkeepingColumnsSql())
+}
+
+// Each query returns the DDL of one kind of object, one statement a row.
+// postgres has no function returning the DDL of a table, a type or a
+// sequence, so those are put together from the catalog. Comments and
+// privileges are not included. Needs postgres 13 or later.
+var POSTGRES_CATALOG_QUERIES = []string{
+  `select format('CREATE SCHEMA %I', n.nspname)
+  from pg_namespace n
+  where ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_namespace", "n.oid") + `
+    and not (
+      exists (select from pg_class c where c.relnamespace = n.oid and c.relkind = 'r' and not ` + postgresNotBookkeeping("c.oid") + `)
+      and not exists (select from pg_class c where c.relnamespace = n.oid and c.relkind in ('r', 'p', 'v', 'm', 'f', 'c') and ` + postgresNotBookkeeping("c.oid") + `)
+      and not exists (select from pg_proc p where p.pronamespace = n.oid)
+      and not exists (select from pg_type t where t.typnamespace = n.oid and t.typtype in ('e', 'd'))
+    )`,
+
+  `select format('CREATE EXTENSION %I WITH SCHEMA %I', e.extname, n.nspname)
+  from pg_extension e
+  join pg_namespace n on n.oid = e.extnamespace
+  where n.nspname <> $1`,
+
+  `select format('CREATE TYPE %I.%I AS ENUM (%s)', n.nspname, t.typname, (
+    select string_agg(quote_literal(e.enumlabel), ', ' order by e.enumsortorder) from pg_enum e where e.enumtypid = t.oid
+  ))
+  from pg_type t
+  join pg_namespace n on n.oid = t.typnamespace
+  where t.typtype = 'e' and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_type", "t.oid"),
+
+  `select format('CREATE DOMAIN %I.%I AS %s%s%s%s', n.nspname, t.typname, format_type(t.typbasetype, t.typtypmod),
+    coalesce(' DEFAULT ' || t.typdefault, ''),
+    case when t.typnotnull then ' NOT NULL' else '' end,
+    coalesce((
+      select string_agg(format(' CONSTRAINT %I %s', c.conname, pg_get_constraintdef(c.oid)), '' order by c.conname)
+      from pg_constraint c where c.contypid = t.oid and c.contype = 'c'
+    ), ''))
+  from pg_type t
+  join pg_namespace n on n.oid = t.typnamespace
+  where t.typtype = 'd' and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_type", "t.oid"),
+
+  `select format('CREATE TYPE %I.%I AS (%s)', n.nspname, t.typname, (
+    select string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod)), ', ' order by a.attnum)
+    from pg_attribute a where a.attrelid = t.typrelid and a.attnum > 0 and not a.attisdropped
+  ))
+  from pg_type t
+  join pg_namespace n on n.oid = t.typnamespace
+  join pg_class c on c.oid = t.typrelid
+  where t.typtype = 'c' and c.relkind = 'c' and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_type", "t.oid"),
+
+  // Identity columns create their sequences themselves.
+  `select format('CREATE SEQUENCE %I.%I AS %s INCREMENT BY %s MINVALUE %s MAXVALUE %s START WITH %s CACHE %s%s',
+    n.nspname, c.relname, format_type(s.seqtypid, null), s.seqincrement, s.seqmin, s.seqmax, s.seqstart, s.seqcache,
+    case when s.seqcycle then ' CYCLE' else '' end)
+  from pg_sequence s
+  join pg_class c on c.oid = s.seqrelid
+  join pg_namespace n on n.oid = c.relnamespace
+  where ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + `
+    and not exists (select from pg_depend d where d.classid = 'pg_class'::regclass and d.objid = c.oid and d.deptype = 'i')
+    and not exists (
+      select from pg_depend d
+      where d.classid = 'pg_class'::regclass and d.objid = c.oid and d.deptype = 'a'
+        and d.refclassid = 'pg_class'::regclass and not ` + postgresNotBookkeeping("d.refobjid") + `
+    )`,
+
+  // Columns inherited from a parent table or partitioned table are left to it.
+  `select case when c.relispartition then
+      format('CREATE TABLE %I.%I PARTITION OF %I.%I %s', n.nspname, c.relname, pn.nspname, p.relname, pg_get_expr(c.relpartbound, c.oid))
+    else
+      format('CREATE %sTABLE %I.%I (%s)%s%s', case when c.relpersistence = 'u' then 'UNLOGGED ' else '' end, n.nspname, c.relname,
+        coalesce((
+          select string_agg(format('%I %s', a.attname, format_type(a.atttypid, a.atttypmod))
+            || coalesce((
+              select format(' COLLATE %I.%I', cn.nspname, co.collname)
+              from pg_collation co
+              join pg_namespace cn on cn.oid = co.collnamespace
+              join pg_type ct on ct.oid = a.atttypid
+              where co.oid = a.attcollation and a.attcollation <> ct.typcollation
+            ), '')
+            || case
+              when a.attgenerated = 's' then ' GENERATED ALWAYS AS (' || pg_get_expr(ad.adbin, ad.adrelid) || ') STORED'
+              when ad.adbin is not null then ' DEFAULT ' || pg_get_expr(ad.adbin, ad.adrelid)
+              else ''
+            end
+            || case a.attidentity when 'a' then ' GENERATED ALWAYS AS IDENTITY' when 'd' then ' GENERATED BY DEFAULT AS IDENTITY' else '' end
+            || case when a.attnotnull then ' NOT NULL' else '' end, ', ' order by a.attnum)
+          from pg_attribute a
+          left join pg_attrdef ad on ad.adrelid = a.attrelid and ad.adnum = a.attnum
+          where a.attrelid = c.oid and a.attnum > 0 and not a.attisdropped and a.attislocal
+        ), ''),
+        coalesce(' INHERITS (' || (
+          select string_agg(format('%I.%I', hn.nspname, h.relname), ', ' order by i.inhseqno)
+          from pg_inherits i
+          join pg_class h on h.oid = i.inhparent
+          join pg_namespace hn on hn.oid = h.relnamespace
+          where i.inhrelid = c.oid
+        ) || ')', ''),
+        case when c.relkind = 'p' then ' PARTITION BY ' || pg_get_partkeydef(c.oid) else '' end)
+    end
+  from pg_class c
+  join pg_namespace n on n.oid = c.relnamespace
+  left join pg_inherits pi on c.relispartition and pi.inhrelid = c.oid
+  left join pg_class p on p.oid = pi.inhparent
+  left join pg_namespace pn on pn.oid = p.relnamespace
+  where c.relkind in ('r', 'p') and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + `
+    and ` + postgresNotBookkeeping("c.oid"),
+
+  // Not null constraints are part of the column, and constraints of a
+  // partition or child table come from its parent.
+  `select format('ALTER TABLE %I.%I ADD CONSTRAINT %I %s', n.nspname, c.relname, co.conname, pg_get_constraintdef(co.oid))
+  from pg_constraint co
+  join pg_class c on c.oid = co.conrelid
+  join pg_namespace n on n.oid = c.relnamespace
+  where co.contype in ('p', 'u', 'f', 'c', 'x') and co.conislocal and co.conparentid = 0
+    and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + ` and ` + postgresNotBookkeeping("c.oid"),
+
+  `select format('ALTER TABLE %I.%I ENABLE ROW LEVEL SECURITY', n.nspname, c.relname)
+  from pg_class c
+  join pg_namespace n on n.oid = c.relnamespace
+  where c.relrowsecurity and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + `
+    and ` + postgresNotBookkeeping("c.oid"),
+
+  // Indexes behind a constraint are created by it, and the indexes of
+  // partitions by the index of the partitioned table.
+  `select pg_get_indexdef(i.indexrelid)
+  from pg_index i
+  join pg_class c on c.oid = i.indexrelid
+  join pg_namespace n on n.oid = c.relnamespace
+  where ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "i.indrelid") + ` and ` + postgresNotBookkeeping("i.indrelid") + `
+    and not exists (select from pg_constraint co where co.conindid = i.indexrelid and co.contype in ('p', 'u', 'x'))
+    and not exists (select from pg_inherits h where h.inhrelid = i.indexrelid)`,
+
+  `select format('CREATE %sVIEW %I.%I AS %s', case when c.relkind = 'm' then 'MATERIALIZED ' else '' end, n.nspname, c.relname, rtrim(pg_get_viewdef(c.oid), ';'))
+  from pg_class c
+  join pg_namespace n on n.oid = c.relnamespace
+  where c.relkind in ('v', 'm') and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid"),
+
+  `select pg_get_functiondef(p.oid)
+  from pg_proc p
+  join pg_namespace n on n.oid = p.pronamespace
+  where p.prokind in ('f', 'p') and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_proc", "p.oid"),
+
+  `select pg_get_triggerdef(t.oid)
+  from pg_trigger t
+  join pg_class c on c.oid = t.tgrelid
+  join pg_namespace n on n.oid = c.relnamespace
+  where not t.tgisinternal and t.tgparentid = 0 and ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + `
+    and ` + postgresNotBookkeeping("c.oid"),
+
+  `select format('CREATE POLICY %I ON %I.%I AS %s FOR %s TO %s%s%s', pol.polname, n.nspname, c.relname,
+    case when pol.polpermissive then 'PERMISSIVE' else 'RESTRICTIVE' end,
+    case pol.polcmd when 'r' then 'SELECT' when 'a' then 'INSERT' when 'w' then 'UPDATE' when 'd' then 'DELETE' else 'ALL' end,
+    (
+      select string_agg(role_name, ', ' order by role_name)
+      from (select case when r = 0 then 'PUBLIC' else quote_ident(pg_get_userbyid(r)) end as role_name from unnest(pol.polroles) as r) roles
+    ),
+    coalesce(' USING (' || pg_get_expr(pol.polqual, pol.polrelid) || ')', ''),
+    coalesce(' WITH CHECK (' || pg_get_expr(pol.polwithcheck, pol.polrelid) || ')', ''))
+  from pg_policy pol
+  join pg_class c on c.oid = pol.polrelid
+  join pg_namespace n on n.oid = c.relnamespace
+  where ` + POSTGRES_USER_SCHEMA + ` and ` + postgresNotInExtension("pg_class", "c.oid") + ` and ` + postgresNotBookkeeping("c.oid"),
+}
+
+// Runs query and returns the first column of every row.
+func queryStrings(ctx *Context, query string, args ...any) ([]string, error) {
+  rows, err := ctx.DbTx.QueryContext(getCtx(ctx), query, args...)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  var values []string
+
+  for rows.Next() {
+    var value string
+
+    if err := rows.Scan(&value); err != nil {
+      return nil, err
+    }
+
+    values = append(values, value)
+  }
+
+  return values, rows.Err()
+}
+
+func (postgresDialect) CatalogStmts(ctx *Context) ([]string, error) {
+  var stmts []string
+
+  version, err := queryStrings(ctx, "select current_setting('server_version_num')")
+
+  if err != nil {
+    return nil, fmt.Errorf("reading the server version: %w", err)
+  }
+
+  if len(version) == 1 {
+    if number, err := strconv.Atoi(version[0]); err == nil && number < POSTGRES_CATALOG_MIN_VERSION {
+      return nil, fmt.Errorf("the snapshot needs postgres 13 or later, the server is version %s", version[0])
+    }
+  }
+
+  for _, query := range POSTGRES_CATALOG_QUERIES {
+    found, err := queryStrings(ctx, query, getBookkeepingSchema(ctx))
+
+    if err != nil {
+      return nil, fmt.Errorf("reading the catalog: %w", err)
+    }
+
+    stmts = append(stmts, found...)
+  }
+
+  return stmts, nil
+}
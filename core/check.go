@@ -0,0 +1,133 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+type CheckResult struct {
+  Unresolved []string
+  Tampered []string
+  SyntaxErrors []string
+  // Statements in the schema files that no migration has been made for.
+  Changes []string
+}
+
+func (r *CheckResult) Passed() bool {
+  return len(r.Unresolved) == 0 && len(r.Tampered) == 0 && len(r.SyntaxErrors) == 0 && len(r.Changes) == 0
+}
+
+func getSchemaFilesWithSyntaxErrors(ctx *Context) ([]string, error) {
+  var broken []string
+
+  files, err := ListAllFilesInPath(ctx.SqlPath)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, file := range files {
+    code, err := readFileToString(ctx, file)
+
+    if err != nil {
+      return nil, err
+    }
+
+    if _, err := getDialect(ctx).ParseStmts(code); err != nil {
+      broken = append(broken, fmt.Sprintf("%s: %v", file, err))
+    }
+  }
+
+  return broken, nil
+}
+
+func getStatementsWithoutMigrations(ctx *Context) ([]string, error) {
+  var pending []string
+
+  stmts, err := buildParsedStmts(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  ctx.Stmts = stmts
+
+  for _, stmt := range *ctx.Stmts {
+    switch stmt.Status {
+      case NEW: {
+        pending = append(pending, fmt.Sprintf("new: %s", stmt.Deparsed))
+      }
+
+      case CHANGED: {
+        pending = append(pending, fmt.Sprintf("changed: %s", stmt.Deparsed))
+      }
+    }
+  }
+
+  removed, err := getRemovedStatements(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, r := range removed {
+    pending = append(pending, fmt.Sprintf("removed: %s", *r.stmt))
+  }
+
+  return pending, nil
+}
+
+func reportCheckFailure(ctx *Context, problem string, drift string, items []string) {
+  for _, item := range items {
+    getLogger(ctx).Warn(problem, "drift", drift, "item", item)
+    event := Event { Type: EVENT_DRIFT_FOUND, Detail: drift }
+
+    if drift == DRIFT_UNRESOLVED || drift == DRIFT_TAMPERED {
+      event.File = item
+    } else {
+      event.Stmt = item
+    }
+
+    emit(ctx, event)
+  }
+}
+
+// Check is a read only version of setup and make, meant for CI. Callers must
+// roll back the transaction afterwards.
+func Check(ctx *Context) (*CheckResult, error) {
+  var err error
+
+  result := new(CheckResult)
+
+  if result.Unresolved, err = getMigrationFilesWithUnresolvedMigrations(ctx); err != nil {
+    return nil, err
+  }
+
+  if result.Tampered, err = getTamperedMigrations(ctx); err != nil {
+    return nil, err
+  }
+
+  if result.SyntaxErrors, err = getSchemaFilesWithSyntaxErrors(ctx); err != nil {
+    return nil, err
+  }
+
+  // Changes can only be computed when every schema file parses.
+  if len(result.SyntaxErrors) == 0 {
+    if result.Changes, err = getStatementsWithoutMigrations(ctx); err != nil {
+      var syntax_err *SyntaxError
+
+      if !errors.As(err, &syntax_err) {
+        return nil, err
+      }
+
+      result.SyntaxErrors = append(result.SyntaxErrors, syntax_err.Error())
+    }
+  }
+
+  reportCheckFailure(ctx, "Migration with unresolved changes", DRIFT_UNRESOLVED, result.Unresolved)
+  reportCheckFailure(ctx, "Executed migration has been tampered with", DRIFT_TAMPERED, result.Tampered)
+  reportCheckFailure(ctx, "Schema file fails to parse", DRIFT_SYNTAX_ERROR, result.SyntaxErrors)
+  reportCheckFailure(ctx, fmt.Sprintf("Change in %s without a migration", ctx.SqlPath), DRIFT_SCHEMA_CHANGE, result.Changes)
+
+  return result, nil
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Looked up in the current directory, in this order, when --config is not given.
+var CONFIG_FILE_NAMES = []string{ "schemaflow.yaml", "schemaflow.yml", "schemaflow.toml" }
+
+// Options that only make sense on the command line.
+var CONFIG_FLAGS_NOT_ALLOWED = []string{ "config", "env" }
+
+// The keys of each section are flag names without the dashes, e.g.
+//
+//   default:
+//     sql-path: ./schema
+//   environments:
+//     prod:
+//       host: db.internal
+type ConfigFile struct {
+  Default map[string]any `yaml:"default" toml:"default"`
+  Environments map[string]map[string]any `yaml:"environments" toml:"environments"`
+}
+
+// Returns --config, or the first of CONFIG_FILE_NAMES in dir. An empty path
+// means there is no config file.
+func findConfigFile(dir string, config_path string) (string, error) {
+  if config_path != "" {
+    if !DoesPathExist(config_path) {
+      return "", fmt.Errorf("config file %s does not exist", config_path)
+    }
+
+    return config_path, nil
+  }
+
+  for _, name := range CONFIG_FILE_NAMES {
+    path := filepath.Join(dir, name)
+
+    if DoesPathExist(path) {
+      return path, nil
+    }
+  }
+
+  return "", nil
+}
+
+func readConfigFile(path string) (*ConfigFile, error) {
+  config := new(ConfigFile)
+
+  data, err := os.ReadFile(path)
+
+  if err != nil {
+    return nil, err
+  }
+
+  if strings.HasSuffix(path, ".toml") {
+    err = toml.Unmarshal(data, config)
+  } else {
+    err = yaml.Unmarshal(data, config)
+  }
+
+  if err != nil {
+    return nil, fmt.Errorf("%s: %w", path, err)
+  }
+
+  return config, nil
+}
+
+func configValueToString(value any) string {
+  // Lists such as lint-disable are comma separated on the command line.
+  if list, ok := value.([]any); ok {
+    var items []string
+
+    for _, item := range list {
+      items = append(items, fmt.Sprint(item))
+    }
+
+    return strings.Join(items, ",")
+  }
+
+  return fmt.Sprint(value)
+}
+
+// The default section with the env section on top of it.
+func getConfigOptions(config *ConfigFile, env string) (map[string]string, error) {
+  options := make(map[string]string)
+
+  for key, value := range config.Default {
+    options[key] = configValueToString(value)
+  }
+
+  if env == "" {
+    return options, nil
+  }
+
+  section, ok := config.Environments[env]
+
+  if !ok {
+    var envs []string
+
+    for name := range config.Environments {
+      envs = append(envs, name)
+    }
+
+    sort.Strings(envs)
+    return nil, fmt.Errorf("environment '%s' is not in the config file. Available environments: %s", env, strings.Join(envs, ", "))
+  }
+
+  for key, value := range section {
+    options[key] = configValueToString(value)
+  }
+
+  return options, nil
+}
+
+// Sets every flag in options that was not given on the command line, so
+// that flags override the config file.
+func applyConfigOptions(flags *flag.FlagSet, options map[string]string) error {
+  set := make(map[string]bool)
+
+  flags.Visit(func(f *flag.Flag) {
+    set[f.Name] = true
+  })
+
+  var keys []string
+
+  for key := range options {
+    keys = append(keys, key)
+  }
+
+  sort.Strings(keys)
+
+  for _, key := range keys {
+    if flags.Lookup(key) == nil || slices.Contains(CONFIG_FLAGS_NOT_ALLOWED, key) {
+      return fmt.Errorf("unknown option '%s' in config file", key)
+    }
+
+    if set[key] {
+      continue
+    }
+
+    if err := flags.Set(key, options[key]); err != nil {
+      return fmt.Errorf("option '%s' in config file: %w", key, err)
+    }
+  }
+
+  return nil
+}
+
+// Loads --config, or a config file in the current directory, and applies
+// the default section and the --env section to flags.
+func loadConfig(flags *flag.FlagSet, config_path string, env string) error {
+  path, err := findConfigFile(".", config_path)
+
+  if err != nil {
+    return err
+  }
+
+  if path == "" {
+    if env != "" {
+      return fmt.Errorf("'env' was given but there is no config file")
+    }
+
+    return nil
+  }
+
+  config, err := readConfigFile(path)
+
+  if err != nil {
+    return err
+  }
+
+  options, err := getConfigOptions(config, env)
+
+  if err != nil {
+    return err
+  }
+
+  return applyConfigOptions(flags, options)
+}
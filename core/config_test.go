@@ -0,0 +1,99 @@
+package core
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newConfigTestFlags(args []string) (*flag.FlagSet, map[string]*string) {
+  flags := flag.NewFlagSet("schemaflow", flag.PanicOnError)
+
+  values := map[string]*string{
+    "host": flags.String("host", "127.0.0.1", "host"),
+    "db": flags.String("db", "", "db"),
+    "sql-path": flags.String("sql-path", "./", "sql-path"),
+    "lint-disable": flags.String("lint-disable", "", "lint-disable"),
+  }
+
+  flags.String("env", "", "env")
+  perr(flags.Parse(args))
+
+  return flags, values
+}
+
+func TestConfigFile(t *testing.T) {
+  configs := map[string]string{
+    "schemaflow.yaml": `
+default:
+  sql-path: ./schema
+  db: app
+  lint-disable: [drop-table, drop-column]
+environments:
+  prod:
+    host: db.internal
+    db: app_prod
+`,
+    "schemaflow.toml": `
+[default]
+sql-path = "./schema"
+db = "app"
+lint-disable = ["drop-table", "drop-column"]
+
+[environments.prod]
+host = "db.internal"
+db = "app_prod"
+`,
+  }
+
+  for name, code := range configs {
+    t.Run(name, func(t *testing.T) {
+      path := filepath.Join(t.TempDir(), name)
+      perr(os.WriteFile(path, []byte(code), 0644))
+
+      flags, values := newConfigTestFlags([]string{ "--db=from_cli" })
+      perr(loadConfig(flags, path, "prod"))
+
+      got := map[string]string{}
+
+      for key, value := range values {
+        got[key] = *value
+      }
+
+      correct := map[string]string{
+        "host": "db.internal",
+        "db": "from_cli",
+        "sql-path": "./schema",
+        "lint-disable": "drop-table,drop-column",
+      }
+
+      if !reflect.DeepEqual(got, correct) {
+        test_failed(t, got, correct)
+      }
+    })
+  }
+
+  t.Run("unknown environment", func(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "schemaflow.yaml")
+    perr(os.WriteFile(path, []byte(configs["schemaflow.yaml"]), 0644))
+
+    flags, _ := newConfigTestFlags(nil)
+
+    if e := loadConfig(flags, path, "staging"); e == nil {
+      t.Errorf("expected an error for a missing environment")
+    }
+  })
+
+  t.Run("unknown option", func(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "schemaflow.yaml")
+    perr(os.WriteFile(path, []byte("default:\n  env: prod\n"), 0644))
+
+    flags, _ := newConfigTestFlags(nil)
+
+    if e := loadConfig(flags, path, ""); e == nil {
+      t.Errorf("expected an error for an option that can't be set in the config file")
+    }
+  })
+}
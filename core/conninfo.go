@@ -0,0 +1,393 @@
+package core
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+)
+
+// Postgres connection options are resolved the way libpq resolves them:
+// explicit options first, then --url or DATABASE_URL, then the service file
+// section named by PGSERVICE. lib/pq fills in whatever is still missing from
+// PGHOST, PGPORT, PGUSER, PGPASSWORD, PGDATABASE and ~/.pgpass.
+
+// The values of sslmode, as in libpq.
+var SSL_MODES = []string{ "disable", "allow", "prefer", "require", "verify-ca", "verify-full" }
+
+// Parses a postgres:// or postgresql:// URL into connection options.
+func parsePostgresUrl(raw string) (map[string]string, error) {
+  options := make(map[string]string)
+
+  u, err := url.Parse(raw)
+
+  if err != nil {
+    return nil, fmt.Errorf("invalid database url: %w", err)
+  }
+
+  if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+    return nil, fmt.Errorf("invalid database url: scheme must be postgres or postgresql, not '%s'", u.Scheme)
+  }
+
+  accrue := func(key string, value string) {
+    if value != "" {
+      options[key] = value
+    }
+  }
+
+  if u.User != nil {
+    accrue("user", u.User.Username())
+    password, _ := u.User.Password()
+    accrue("password", password)
+  }
+
+  if host, port, err := net.SplitHostPort(u.Host); err != nil {
+    accrue("host", u.Host)
+  } else {
+    accrue("host", host)
+    accrue("port", port)
+  }
+
+  accrue("dbname", strings.TrimPrefix(u.Path, "/"))
+
+  for key, values := range u.Query() {
+    accrue(key, values[len(values) - 1])
+  }
+
+  return options, nil
+}
+
+// The service files searched for a service, in order: PGSERVICEFILE or
+// ~/.pg_service.conf, then pg_service.conf in PGSYSCONFDIR.
+func getServiceFiles() []string {
+  var files []string
+
+  if path := os.Getenv("PGSERVICEFILE"); path != "" {
+    files = append(files, path)
+  } else if home, err := os.UserHomeDir(); err == nil {
+    files = append(files, filepath.Join(home, ".pg_service.conf"))
+  }
+
+  if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+    files = append(files, filepath.Join(dir, "pg_service.conf"))
+  }
+
+  return files
+}
+
+// Reads the [service] section of an INI style service file. found is false
+// when the file or the section doesn't exist.
+func readServiceFile(path string, service string) (map[string]string, bool, error) {
+  file, err := os.Open(path)
+
+  if os.IsNotExist(err) {
+    return nil, false, nil
+  }
+
+  if err != nil {
+    return nil, false, err
+  }
+
+  defer file.Close()
+
+  var options map[string]string
+  scanner := bufio.NewScanner(file)
+  line_number := 0
+
+  for scanner.Scan() {
+    line_number++
+    line := strings.TrimSpace(scanner.Text())
+
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+
+    if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+      if options != nil {
+        break
+      }
+
+      if strings.TrimSpace(line[1:len(line) - 1]) == service {
+        options = make(map[string]string)
+      }
+
+      continue
+    }
+
+    if options == nil {
+      continue
+    }
+
+    key, value, ok := strings.Cut(line, "=")
+
+    if !ok {
+      return nil, false, fmt.Errorf("%s:%d: expected key=value", path, line_number)
+    }
+
+    options[strings.TrimSpace(key)] = strings.TrimSpace(value)
+  }
+
+  if err := scanner.Err(); err != nil {
+    return nil, false, err
+  }
+
+  return options, options != nil, nil
+}
+
+func getServiceOptions(service string) (map[string]string, error) {
+  files := getServiceFiles()
+
+  for _, path := range files {
+    options, found, err := readServiceFile(path, service)
+
+    if err != nil {
+      return nil, err
+    }
+
+    if found {
+      return options, nil
+    }
+  }
+
+  return nil, fmt.Errorf("service '%s' not found in %s", service, strings.Join(files, ", "))
+}
+
+// Decrypts a PEM encoded private key, either PKCS#8 or in the legacy
+// OpenSSL format.
+func decryptPemKey(data []byte, password string) ([]byte, error) {
+  block, _ := pem.Decode(data)
+
+  if block == nil {
+    return nil, fmt.Errorf("no PEM data found")
+  }
+
+  if block.Type == "ENCRYPTED PRIVATE KEY" {
+    key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+
+    if err != nil {
+      return nil, err
+    }
+
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+
+    if err != nil {
+      return nil, err
+    }
+
+    return pem.EncodeToMemory(&pem.Block{ Type: "PRIVATE KEY", Bytes: der }), nil
+  }
+
+  if !x509.IsEncryptedPEMBlock(block) {
+    return data, nil
+  }
+
+  der, err := x509.DecryptPEMBlock(block, []byte(password))
+
+  if err != nil {
+    return nil, err
+  }
+
+  return pem.EncodeToMemory(&pem.Block{ Type: block.Type, Bytes: der }), nil
+}
+
+// Returns the option, its environment variable, or the file in ~/.postgresql
+// libpq would use.
+func getSslPath(options map[string]string, key string, env string, name string) string {
+  if path := options[key]; path != "" {
+    return path
+  }
+
+  if path := os.Getenv(env); path != "" {
+    return path
+  }
+
+  if name == "" {
+    return ""
+  }
+
+  home, err := os.UserHomeDir()
+
+  if err != nil {
+    return ""
+  }
+
+  return filepath.Join(home, ".postgresql", name)
+}
+
+// lib/pq can't decrypt client keys, so the key is decrypted here and the
+// certificates are passed inline instead of as paths.
+func inlineSslFiles(options map[string]string, ssl_password string) error {
+  cert_path := getSslPath(options, "sslcert", "PGSSLCERT", "postgresql.crt")
+  key_path := getSslPath(options, "sslkey", "PGSSLKEY", "postgresql.key")
+  root_cert_path := getSslPath(options, "sslrootcert", "PGSSLROOTCERT", "")
+
+  cert, err := os.ReadFile(cert_path)
+
+  if err != nil {
+    return fmt.Errorf("sslcert: %w", err)
+  }
+
+  key, err := os.ReadFile(key_path)
+
+  if err != nil {
+    return fmt.Errorf("sslkey: %w", err)
+  }
+
+  key, err = decryptPemKey(key, ssl_password)
+
+  if err != nil {
+    return fmt.Errorf("sslkey %s: %w", key_path, err)
+  }
+
+  options["sslcert"] = string(cert)
+  options["sslkey"] = string(key)
+  options["sslinline"] = "true"
+
+  // "system" means the system's CAs, not a file.
+  if root_cert_path == "system" {
+    options["sslrootcert"] = root_cert_path
+  } else if root_cert_path != "" {
+    root_cert, err := os.ReadFile(root_cert_path)
+
+    if err != nil {
+      return fmt.Errorf("sslrootcert: %w", err)
+    }
+
+    options["sslrootcert"] = string(root_cert)
+  }
+
+  return nil
+}
+
+// Quotes a value for a key=value connection string.
+func quoteConnValue(value string) string {
+  return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// Builds the lib/pq connection string for db_ctx. Options left out of it are
+// read from the environment by lib/pq.
+func buildPostgresConnString(db_ctx *DbContext) (string, error) {
+  options := make(map[string]string)
+
+  raw_url := db_ctx.PgUrl
+
+  if raw_url == "" {
+    raw_url = os.Getenv("DATABASE_URL")
+  }
+
+  if raw_url != "" {
+    url_options, err := parsePostgresUrl(raw_url)
+
+    if err != nil {
+      return "", err
+    }
+
+    options = url_options
+  }
+
+  service := options["service"]
+  delete(options, "service")
+
+  if service == "" {
+    service = os.Getenv("PGSERVICE")
+  }
+
+  if service != "" {
+    service_options, err := getServiceOptions(service)
+
+    if err != nil {
+      return "", err
+    }
+
+    for key, value := range service_options {
+      if _, ok := options[key]; !ok {
+        options[key] = value
+      }
+    }
+  }
+
+  if db_ctx.PgHost != "" {
+    options["host"] = db_ctx.PgHost
+  }
+
+  if db_ctx.PgPort != 0 {
+    options["port"] = strconv.Itoa(db_ctx.PgPort)
+  }
+
+  if db_ctx.PgUser != "" {
+    options["user"] = db_ctx.PgUser
+  }
+
+  if db_ctx.PgPassword != "" {
+    options["password"] = db_ctx.PgPassword
+  }
+
+  if db_ctx.PgDbName != "" {
+    options["dbname"] = db_ctx.PgDbName
+  }
+
+  ssl_mode := db_ctx.PgSSLMode
+
+  if ssl_mode == "" && db_ctx.PgSSL {
+    ssl_mode = "require"
+  }
+
+  if ssl_mode != "" {
+    if !slices.Contains(SSL_MODES, ssl_mode) {
+      return "", fmt.Errorf("unknown sslmode '%s'. Available modes: %s", ssl_mode, strings.Join(SSL_MODES, ", "))
+    }
+
+    options["sslmode"] = ssl_mode
+  } else if _, ok := options["sslmode"]; !ok && os.Getenv("PGSSLMODE") == "" {
+    options["sslmode"] = "disable"
+  }
+
+  for key, value := range map[string]string{
+    "sslrootcert": db_ctx.PgSSLRootCert,
+    "sslcert": db_ctx.PgSSLCert,
+    "sslkey": db_ctx.PgSSLKey,
+    "sslpassword": db_ctx.PgSSLPassword,
+  } {
+    if value != "" {
+      options[key] = value
+    }
+  }
+
+  // Without a client key there is nothing to decrypt, and lib/pq doesn't
+  // know sslpassword.
+  if ssl_password, ok := options["sslpassword"]; ok {
+    delete(options, "sslpassword")
+
+    if options["sslkey"] != "" || os.Getenv("PGSSLKEY") != "" {
+      if err := inlineSslFiles(options, ssl_password); err != nil {
+        return "", err
+      }
+    }
+  }
+
+  var keys []string
+
+  for key := range options {
+    keys = append(keys, key)
+  }
+
+  sort.Strings(keys)
+
+  var pairs []string
+
+  for _, key := range keys {
+    pairs = append(pairs, key + "=" + quoteConnValue(options[key]))
+  }
+
+  return strings.Join(pairs, " "), nil
+}
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Returns the first dependency cycle in stmts, starting and ending with the
+// same statement, or nil. A statement depending on itself, like a table with
+// a foreign key to itself, is not a cycle, and neither are soft dependencies.
+func findDependencyCycle(stmts []*ParsedStmt) []*ParsedStmt {
+  const (
+    UNVISITED = iota
+    VISITING
+    VISITED
+  )
+
+  state := make(map[*ParsedStmt]int)
+  var path []*ParsedStmt
+  var cycle []*ParsedStmt
+
+  var visit func(stmt *ParsedStmt) bool
+
+  visit = func(stmt *ParsedStmt) bool {
+    state[stmt] = VISITING
+    path = append(path, stmt)
+
+    for _, dep := range stmt.Dependencies {
+      next := dep.Dependency
+
+      if next == nil || next == stmt || dep.Soft {
+        continue
+      }
+
+      switch state[next] {
+        case VISITING: {
+          for i, s := range path {
+            if s == next {
+              cycle = append(append(cycle, path[i:]...), next)
+              break
+            }
+          }
+
+          return true
+        }
+
+        case UNVISITED: {
+          if visit(next) {
+            return true
+          }
+        }
+      }
+    }
+
+    path = path[:len(path) - 1]
+    state[stmt] = VISITED
+    return false
+  }
+
+  for _, stmt := range stmts {
+    if state[stmt] == UNVISITED && visit(stmt) {
+      return cycle
+    }
+  }
+
+  return nil
+}
+
+// Returns a DependencyCycleError for the first cycle in stmts, or nil.
+func checkDependencyCycles(stmts []*ParsedStmt) error {
+  cycle := findDependencyCycle(stmts)
+
+  if cycle == nil {
+    return nil
+  }
+
+  var path []string
+
+  for _, stmt := range cycle {
+    if stmt.File != "" {
+      path = append(path, fmt.Sprintf("%s (%s)", stmt.Name, stmt.File))
+    } else {
+      path = append(path, stmt.Name)
+    }
+  }
+
+  return &DependencyCycleError { path, suggestCycleSplit(cycle) }
+}
+
+// Is c a foreign key to the table named referenced? referenced may have been
+// qualified with a schema from the search path.
+func isForeignKeyTo(c *pg_query.Constraint, referenced string) bool {
+  if c == nil || c.GetContype() != pg_query.ConstrType_CONSTR_FOREIGN {
+    return false
+  }
+
+  pktable := pgRangevarToString(c.GetPktable())
+  return pktable == referenced || (!isQualified(pktable) && strings.HasSuffix(referenced, "." + pktable))
+}
+
+// Splits the foreign keys from table to referenced out of a CREATE TABLE,
+// returning the CREATE TABLE without them and an ALTER TABLE adding them.
+func splitForeignKeys(create *ParsedStmt, referenced string) (string, string, error) {
+  // Parsed again so that the statement itself isn't changed.
+  pr, err := parseSql(create.Deparsed)
+
+  if err != nil {
+    return "", "", err
+  }
+
+  if len(pr.Stmts) != 1 || pr.Stmts[0].GetStmt().GetCreateStmt() == nil {
+    return "", "", fmt.Errorf("%s is not a CREATE TABLE", create.Name)
+  }
+
+  create_stmt := pr.Stmts[0].GetStmt().GetCreateStmt()
+
+  var elts []*pg_query.Node
+  var foreign_keys []*pg_query.Node
+
+  for _, elt := range create_stmt.GetTableElts() {
+    if c := elt.GetConstraint(); isForeignKeyTo(c, referenced) {
+      foreign_keys = append(foreign_keys, elt)
+      continue
+    }
+
+    if cd := elt.GetColumnDef(); cd != nil {
+      var constraints []*pg_query.Node
+
+      for _, constraint := range cd.GetConstraints() {
+        if c := constraint.GetConstraint(); isForeignKeyTo(c, referenced) {
+          // A column constraint names no columns, the table constraint must.
+          c.FkAttrs = []*pg_query.Node{ pg_query.MakeStrNode(cd.GetColname()) }
+          foreign_keys = append(foreign_keys, constraint)
+          continue
+        }
+
+        constraints = append(constraints, constraint)
+      }
+
+      cd.Constraints = constraints
+    }
+
+    elts = append(elts, elt)
+  }
+
+  if len(foreign_keys) == 0 {
+    return "", "", fmt.Errorf("%s has no foreign key to %s", create.Name, referenced)
+  }
+
+  create_stmt.TableElts = elts
+
+  without, err := deparseRawStmt(pr.Stmts[0])
+
+  if err != nil {
+    return "", "", err
+  }
+
+  var cmds []*pg_query.Node
+
+  for _, fk := range foreign_keys {
+    cmds = append(cmds, &pg_query.Node{ Node: &pg_query.Node_AlterTableCmd{ AlterTableCmd: &pg_query.AlterTableCmd{
+      Subtype: pg_query.AlterTableType_AT_AddConstraint,
+      Def: fk,
+      Behavior: pg_query.DropBehavior_DROP_RESTRICT,
+    } } })
+  }
+
+  alter, err := deparseRawStmt(&pg_query.RawStmt{ Stmt: &pg_query.Node{ Node: &pg_query.Node_AlterTableStmt{ AlterTableStmt: &pg_query.AlterTableStmt{
+    Relation: create_stmt.GetRelation(),
+    Cmds: cmds,
+    Objtype: pg_query.ObjectType_OBJECT_TABLE,
+  } } } })
+
+  if err != nil {
+    return "", "", err
+  }
+
+  return without, alter, nil
+}
+
+// Suggests how to break cycle: create one of its tables without the foreign
+// key to the next one, and add the key once both tables exist.
+func suggestCycleSplit(cycle []*ParsedStmt) string {
+  for i := 0; i < len(cycle) - 1; i++ {
+    stmt := cycle[i]
+
+    if stmt.StmtType != TABLE || stmt.Stmt == nil {
+      continue
+    }
+
+    without, alter, err := splitForeignKeys(stmt, cycle[i + 1].Name)
+
+    if err != nil {
+      continue
+    }
+
+    file := stmt.File
+
+    if file == "" {
+      file = "the schema"
+    }
+
+    return fmt.Sprintf("Break it by replacing the CREATE TABLE in %s with:\n\n%s\n\n%s", file, without, alter)
+  }
+
+  return "Break it by creating one of the tables without its foreign key and adding the key with ALTER TABLE ... ADD CONSTRAINT"
+}
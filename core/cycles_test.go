@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseSchemaFile(file string, code string) []*ParsedStmt {
+  stmts, e := postgresDialect{}.ParseStmts(code)
+  perr(e)
+
+  for _, stmt := range stmts {
+    stmt.File = file
+  }
+
+  return stmts
+}
+
+func TestDependencyCycles(t *testing.T) {
+  t.Run("mutual foreign keys", func(t *testing.T) {
+    stmts := append(
+      parseSchemaFile("schema/person.sql", "CREATE TABLE person (id int PRIMARY KEY, favorite_pet_id int REFERENCES pet(id));"),
+      parseSchemaFile("schema/pet.sql", "CREATE TABLE pet (id int PRIMARY KEY, owner_id int, FOREIGN KEY (owner_id) REFERENCES person(id));")...,
+    )
+
+    hydrateDependencies(stmts)
+
+    var cycle_err *DependencyCycleError
+
+    if !errors.As(checkDependencyCycles(stmts), &cycle_err) {
+      t.Fatalf("expected a DependencyCycleError")
+    }
+
+    correct := []string{ "person (schema/person.sql)", "pet (schema/pet.sql)", "person (schema/person.sql)" }
+
+    if !reflect.DeepEqual(cycle_err.Cycle, correct) {
+      test_failed(t, cycle_err.Cycle, correct)
+    }
+
+    for _, part := range []string{
+      "CREATE TABLE person (id int PRIMARY KEY, favorite_pet_id int);",
+      "ALTER TABLE person ADD FOREIGN KEY (favorite_pet_id) REFERENCES pet (id);",
+    } {
+      if !strings.Contains(cycle_err.Suggestion, part) {
+        test_failed(t, cycle_err.Suggestion, part)
+      }
+    }
+  })
+
+  t.Run("the suggested split has no cycle", func(t *testing.T) {
+    stmts := append(
+      parseSchemaFile("schema/person.sql", "CREATE TABLE person (id int PRIMARY KEY, favorite_pet_id int);\nALTER TABLE person ADD FOREIGN KEY (favorite_pet_id) REFERENCES pet (id);"),
+      parseSchemaFile("schema/pet.sql", "CREATE TABLE pet (id int PRIMARY KEY, owner_id int, FOREIGN KEY (owner_id) REFERENCES person(id));")...,
+    )
+
+    hydrateDependencies(stmts)
+    perr(checkDependencyCycles(stmts))
+
+    var order []string
+
+    for _, stmt := range sortStmtsByPriority(stmts) {
+      order = append(order, strings.Fields(stmt.Deparsed)[0] + " " + strings.Fields(stmt.Deparsed)[2])
+    }
+
+    correct := []string{ "CREATE person", "CREATE pet", "ALTER person" }
+
+    if !reflect.DeepEqual(order, correct) {
+      test_failed(t, order, correct)
+    }
+  })
+
+  t.Run("plpgsql functions calling each other", func(t *testing.T) {
+    stmts := parseSchemaFile("schema/even.sql", `
+      CREATE FUNCTION is_even(n int) RETURNS boolean AS $$ BEGIN RETURN n = 0 OR is_odd(n - 1); END $$ LANGUAGE plpgsql;
+      CREATE FUNCTION is_odd(n int) RETURNS boolean AS $$ BEGIN RETURN n <> 0 AND is_even(n - 1); END $$ LANGUAGE plpgsql;
+    `)
+
+    hydrateDependencies(stmts)
+    perr(checkDependencyCycles(stmts))
+
+    if sorted := sortStmtsByPriority(stmts); len(sorted) != 2 {
+      test_failed(t, len(sorted), 2)
+    }
+  })
+
+  t.Run("a table referencing itself", func(t *testing.T) {
+    stmts := parseSchemaFile("schema/category.sql", "CREATE TABLE category (id int PRIMARY KEY, parent_id int REFERENCES category(id));")
+
+    hydrateDependencies(stmts)
+    perr(checkDependencyCycles(stmts))
+
+    if sorted := sortStmtsByPriority(stmts); len(sorted) != 1 {
+      test_failed(t, len(sorted), 1)
+    }
+  })
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DEFAULT_CONCURRENCY = 4
+
+// Reads one database name per line. Blank lines and lines starting with #
+// are skipped.
+func ReadDatabaseList(path string) ([]string, error) {
+  var databases []string
+
+  file, err := os.Open(path)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer file.Close()
+
+  scanner := bufio.NewScanner(file)
+
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+
+    databases = append(databases, line)
+  }
+
+  return databases, scanner.Err()
+}
+
+// Runs query against the database in ctx.DbContext and returns the first
+// column of each row.
+func queryNames(ctx *Context, query string, args ...any) ([]string, error) {
+  var names []string
+
+  db, err := CreateDbConnections(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer db.Close()
+
+  rows, err := db.QueryContext(getCtx(ctx), query, args...)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer rows.Close()
+
+  for rows.Next() {
+    var name string
+
+    if err := rows.Scan(&name); err != nil {
+      return nil, err
+    }
+
+    names = append(names, name)
+  }
+
+  return names, rows.Err()
+}
+
+func getConcurrency(ctx *Context) int {
+  if ctx.Concurrency < 1 {
+    return DEFAULT_CONCURRENCY
+  }
+
+  return ctx.Concurrency
+}
+
+// A copy of ctx for the database or schema of result. Its logs and events
+// are tagged with it, and its events are passed on one at a time.
+func getTargetContext(ctx *Context, result *DatabaseResult, observer_mu *sync.Mutex) *Context {
+  db_ctx := *ctx.DbContext
+
+  if result.Database != "" {
+    db_ctx.PgDbName = result.Database
+  }
+
+  target := *ctx
+  target.DbContext = &db_ctx
+  target.Db = nil
+  target.DbTx = nil
+  target.Stmts = nil
+  target.Schema = result.Schema
+
+  if logger, ok := getLogger(ctx).(*slog.Logger); ok {
+    if result.Schema != "" {
+      target.Logger = logger.With("schema", result.Schema)
+    } else {
+      target.Logger = logger.With("database", result.Database)
+    }
+  }
+
+  if ctx.Observer != nil {
+    target.Observer = func(event Event) {
+      observer_mu.Lock()
+      defer observer_mu.Unlock()
+
+      event.Database = result.Database
+      event.Schema = result.Schema
+      ctx.Observer(event)
+    }
+  }
+
+  return &target
+}
+
+// Opens ctx.DbContext and runs migrate in its own transaction, which is
+// committed unless it's a dry run.
+func migrateTarget(ctx *Context) (*MigrateResult, error) {
+  db, err := CreateDbConnections(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer db.Close()
+
+  tx, err := db.BeginTx(getCtx(ctx), nil)
+
+  if err != nil {
+    return nil, err
+  }
+
+  ctx.Db = db
+  ctx.DbTx = tx
+
+  result, err := func() (*MigrateResult, error) {
+    if err := Initialize(ctx); err != nil {
+      return nil, err
+    }
+
+    return Migrate(ctx)
+  }()
+
+  if err != nil || IsReadOnly(ctx) {
+    tx.Rollback()
+    return result, err
+  }
+
+  return result, tx.Commit()
+}
+
+// Migrates the database or schema of each result, at most ctx.Concurrency at
+// a time. Unless ctx.ContinueOnError is set, the ones that haven't started
+// when one fails are skipped.
+func migrateEach(ctx *Context, results []*DatabaseResult) {
+  slots := make(chan struct{}, getConcurrency(ctx))
+  observer_mu := new(sync.Mutex)
+
+  var wg sync.WaitGroup
+  var failed atomic.Bool
+
+  for _, result := range results {
+    slots <- struct{}{}
+
+    if failed.Load() && !ctx.ContinueOnError {
+      <-slots
+      result.Skipped = true
+      continue
+    }
+
+    wg.Add(1)
+
+    go func(result *DatabaseResult) {
+      defer wg.Done()
+      defer func() { <-slots }()
+
+      target := getTargetContext(ctx, result, observer_mu)
+      start := time.Now()
+
+      result.Result, result.Err = migrateTarget(target)
+      result.Duration = time.Since(start)
+
+      if result.Err != nil {
+        failed.Store(true)
+        getLogger(target).Error("Migrate failed", "error", result.Err)
+      }
+    }(result)
+  }
+
+  wg.Wait()
+}
+
+// Migrates every database in ctx.Databases and the result of
+// ctx.DatabasesQuery, each with its own connection and transaction. Results
+// are in the order of the list.
+func MigrateDatabases(ctx *Context) ([]*DatabaseResult, error) {
+  databases := slices.Clone(ctx.Databases)
+
+  if ctx.DatabasesQuery != "" {
+    queried, err := queryNames(ctx, ctx.DatabasesQuery)
+
+    if err != nil {
+      return nil, fmt.Errorf("listing databases: %w", err)
+    }
+
+    databases = append(databases, queried...)
+  }
+
+  if len(databases) == 0 {
+    return nil, fmt.Errorf("there are no databases to migrate")
+  }
+
+  getLogger(ctx).Info("Migrating databases", "databases", len(databases), "concurrency", getConcurrency(ctx))
+
+  var results []*DatabaseResult
+
+  for _, database := range databases {
+    results = append(results, &DatabaseResult{ Database: database })
+  }
+
+  migrateEach(ctx, results)
+  return results, nil
+}
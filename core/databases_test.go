@@ -0,0 +1,146 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMigrateDatabases(t *testing.T) {
+  dir := t.TempDir()
+  migration_path := filepath.Join(dir, "migrations")
+  perr(os.MkdirAll(migration_path, 0755))
+  perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte("CREATE TABLE person (id integer primary key);\n"), 0644))
+
+  databases := []string{
+    filepath.Join(dir, "tenant_1.db"),
+    filepath.Join(dir, "missing", "tenant_2.db"),
+    filepath.Join(dir, "tenant_3.db"),
+  }
+
+  newContext := func() *Context {
+    return &Context{
+      DbContext: &DbContext{},
+      Dialect: sqliteDialect{},
+      MigrationPath: migration_path,
+      Action: MIGRATE,
+      Databases: databases,
+      Concurrency: 1,
+    }
+  }
+
+  summarize := func(results []*DatabaseResult) []string {
+    var summary []string
+
+    for _, result := range results {
+      switch {
+        case result.Skipped: {
+          summary = append(summary, "skipped")
+        }
+
+        case result.Err != nil: {
+          summary = append(summary, "failed")
+        }
+
+        default: {
+          summary = append(summary, result.Result.Executed...)
+        }
+      }
+    }
+
+    return summary
+  }
+
+  t.Run("stops after a failure", func(t *testing.T) {
+    results, e := MigrateDatabases(newContext())
+    perr(e)
+
+    correct := []string{ "0000.sql", "failed", "skipped" }
+
+    if got := summarize(results); !reflect.DeepEqual(got, correct) {
+      test_failed(t, got, correct)
+    }
+  })
+
+  t.Run("continue on error", func(t *testing.T) {
+    ctx := newContext()
+    ctx.ContinueOnError = true
+    ctx.Concurrency = 3
+
+    var mu sync.Mutex
+    migrated := map[string]bool{}
+
+    ctx.Observer = func(event Event) {
+      mu.Lock()
+      defer mu.Unlock()
+
+      if event.Type == EVENT_MIGRATION_FINISHED && event.Err == nil {
+        migrated[event.Database] = true
+      }
+    }
+
+    results, e := MigrateDatabases(ctx)
+    perr(e)
+
+    // tenant_1 was migrated by the previous run.
+    correct := []string{ "failed", "0000.sql" }
+
+    if got := summarize(results); !reflect.DeepEqual(got, correct) {
+      test_failed(t, got, correct)
+    }
+
+    if !reflect.DeepEqual(migrated, map[string]bool{ databases[2]: true }) {
+      test_failed(t, migrated, map[string]bool{ databases[2]: true })
+    }
+  })
+
+  t.Run("database list file", func(t *testing.T) {
+    path := filepath.Join(dir, "tenants.txt")
+    perr(os.WriteFile(path, []byte("# tenants\ntenant_1\n\n  tenant_2  \n"), 0644))
+
+    listed, e := ReadDatabaseList(path)
+    perr(e)
+
+    if !reflect.DeepEqual(listed, []string{ "tenant_1", "tenant_2" }) {
+      test_failed(t, listed, []string{ "tenant_1", "tenant_2" })
+    }
+  })
+}
+
+func TestMigrateSchemas(t *testing.T) {
+  t.Run("schemas are listed once", func(t *testing.T) {
+    schemas, e := getSchemas(&Context{ Schemas: []string{ "tenant_1", "tenant_2", "tenant_1" } })
+    perr(e)
+
+    if !reflect.DeepEqual(schemas, []string{ "tenant_1", "tenant_2" }) {
+      test_failed(t, schemas, []string{ "tenant_1", "tenant_2" })
+    }
+  })
+
+  t.Run("postgres only", func(t *testing.T) {
+    ctx := &Context{ DbContext: &DbContext{}, Dialect: sqliteDialect{}, Schemas: []string{ "tenant_1" } }
+
+    if _, e := MigrateSchemas(ctx); e == nil {
+      t.Errorf("expected an error for a dialect without schemas")
+    }
+  })
+
+  t.Run("bookkeeping parses", func(t *testing.T) {
+    if _, e := parseSql(bookkeepingSql(nil, MIGRATION_SCHEMA)); e != nil {
+      t.Errorf("MIGRATION_SCHEMA: %v", e)
+    }
+  })
+
+  t.Run("statements hash index", func(t *testing.T) {
+    ctx := &Context{ BookkeepingSchema: "platform", TablePrefix: "sf_" }
+
+    got := bookkeepingSql(ctx, "create index if not exists {statements_hash_index} on {statements}(stmt_hash);")
+    correct := "create index if not exists sf_statements_stmt_hash_idx on platform.sf_statements(stmt_hash);"
+
+    if got != correct {
+      test_failed(t, got, correct)
+    }
+  })
+}
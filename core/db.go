@@ -1,41 +1,118 @@
 package core
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/lib/pq/pqerror"
 )
 
-func CreateDbConnections(db_ctx *DbContext) *sql.DB {
-  db_conn_str := fmt.Sprintf("port=%d", db_ctx.PgPort);
-
-  if db_ctx.PgSSL {
-    db_conn_str = fmt.Sprintf("%s sslmode=require", db_conn_str)
-  } else {
-    db_conn_str = fmt.Sprintf("%s sslmode=disable", db_conn_str)
+// Returns ctx.Ctx, or context.Background() when it was not set.
+func getCtx(ctx *Context) context.Context {
+  if ctx.Ctx == nil {
+    return context.Background()
   }
 
-  if db_ctx.PgHost != "" {
-    db_conn_str = fmt.Sprintf("%s host=%s", db_conn_str, db_ctx.PgHost)
+  return ctx.Ctx
+}
+
+// Delays between connection attempts while waiting for the database.
+const WAIT_INITIAL_BACKOFF = 500 * time.Millisecond
+const WAIT_MAX_BACKOFF = 10 * time.Second
+
+// Opens the database described by ctx.DbContext with ctx.Dialect. When
+// ctx.WaitTimeout is set, connecting is retried with exponential backoff
+// until the database is up or the timeout passes.
+func CreateDbConnections(ctx *Context) (*sql.DB, error) {
+  dialect := getDialect(ctx)
+
+  if ctx.WaitTimeout <= 0 {
+    return dialect.Open(getCtx(ctx), ctx.DbContext)
   }
 
-  if db_ctx.PgUser != "" {
-    db_conn_str = fmt.Sprintf("%s user=%s", db_conn_str, db_ctx.PgUser)
+  deadline := time.Now().Add(ctx.WaitTimeout)
+  backoff := WAIT_INITIAL_BACKOFF
+
+  for attempt := 1; ; attempt++ {
+    db, err := dialect.Open(getCtx(ctx), ctx.DbContext)
+
+    if err == nil {
+      if attempt > 1 {
+        getLogger(ctx).Info("Database is ready", "attempts", attempt)
+      }
+
+      return db, nil
+    }
+
+    remaining := time.Until(deadline)
+
+    if !isTransientConnError(err) || remaining <= 0 {
+      return nil, err
+    }
+
+    backoff = min(backoff, remaining)
+    getLogger(ctx).Warn("Database is not ready, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+
+    select {
+      case <-getCtx(ctx).Done(): {
+        return nil, getCtx(ctx).Err()
+      }
+
+      case <-time.After(backoff): {}
+    }
+
+    backoff = min(backoff * 2, WAIT_MAX_BACKOFF)
   }
+}
+
+// Errors seen while the database is still starting: the server isn't
+// listening or resolvable yet, drops the connection, or says it can't accept
+// connections yet. Anything else, like a wrong password, won't go away by
+// waiting.
+func isTransientConnError(err error) bool {
+  var net_err net.Error
+  var pq_err *pq.Error
+
+  switch {
+    case errors.As(err, &net_err): {
+      return true
+    }
 
-  if db_ctx.PgPassword != "" {
-    db_conn_str = fmt.Sprintf("%s password=%s", db_conn_str, db_ctx.PgPassword) 
+    case errors.As(err, &pq_err): {
+      class := pq_err.Code.Class()
+      return class == pqerror.ClassOperatorIntervention || class == pqerror.ClassConnectionException
+    }
   }
 
-  if db_ctx.PgDbName != "" {
-    db_conn_str = fmt.Sprintf("%s dbname=%s", db_conn_str, db_ctx.PgDbName)
+  return errors.Is(err, io.EOF) ||
+    errors.Is(err, io.ErrUnexpectedEOF) ||
+    errors.Is(err, syscall.ECONNREFUSED) ||
+    errors.Is(err, syscall.ECONNRESET) ||
+    errors.Is(err, driver.ErrBadConn) ||
+    errors.Is(err, mysql.ErrInvalidConn)
+}
+
+func openDb(ctx context.Context, driver string, dsn string) (*sql.DB, error) {
+  db_conn, err := sql.Open(driver, dsn)
+
+  if err != nil {
+    return nil, err
   }
 
-  db_conn, err := sql.Open("postgres", db_conn_str)
-  perr(err)
   db_conn.SetMaxOpenConns(20)
-  perr(db_conn.Ping())
 
-  return db_conn
+  if err := db_conn.PingContext(ctx); err != nil {
+    db_conn.Close()
+    return nil, err
+  }
+
+  return db_conn, nil
 }
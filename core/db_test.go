@@ -0,0 +1,84 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestWaitForDatabase(t *testing.T) {
+  t.Run("transient errors", func(t *testing.T) {
+    tests := map[error]bool{
+      &net.OpError{ Op: "dial", Err: errors.New("connection refused") }: true,
+      fmt.Errorf("ping: %w", io.EOF): true,
+      &pq.Error{ Code: "57P03", Message: "the database system is starting up" }: true,
+      &pq.Error{ Code: "28P01", Message: "password authentication failed" }: false,
+      errors.New("unknown sslmode"): false,
+    }
+
+    for err, correct := range tests {
+      if got := isTransientConnError(err); got != correct {
+        test_failed(t, fmt.Sprintf("%v: %v", err, got), correct)
+      }
+    }
+  })
+
+  t.Run("retries until the timeout", func(t *testing.T) {
+    clearConnEnv(t)
+
+    listener, e := net.Listen("tcp", "127.0.0.1:0")
+    perr(e)
+    port := listener.Addr().(*net.TCPAddr).Port
+    listener.Close()
+
+    var buf bytes.Buffer
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgHost: "127.0.0.1", PgPort: port, PgUser: "schemaflow", PgDbName: "schemaflow" },
+      WaitTimeout: time.Second,
+      Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+    }
+
+    started := time.Now()
+
+    if _, e := CreateDbConnections(ctx); e == nil {
+      t.Fatalf("expected an error connecting to a closed port")
+    }
+
+    if elapsed := time.Since(started); elapsed < time.Second {
+      test_failed(t, elapsed, "at least 1s")
+    }
+
+    if retries := strings.Count(buf.String(), "Database is not ready"); retries < 2 {
+      test_failed(t, retries, "at least 2 retries")
+    }
+  })
+
+  t.Run("other errors fail at once", func(t *testing.T) {
+    var buf bytes.Buffer
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgDbName: filepath.Join(t.TempDir(), "missing", "test.db") },
+      Dialect: sqliteDialect{},
+      WaitTimeout: time.Minute,
+      Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+    }
+
+    if _, e := CreateDbConnections(ctx); e == nil {
+      t.Fatalf("expected an error opening a file in a missing directory")
+    }
+
+    if buf.Len() > 0 {
+      test_failed(t, buf.String(), "no retries")
+    }
+  })
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"fmt"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+const DIRECTIVE_ALLOW_DESTRUCTIVE = "allow-destructive"
+
+func getDestructiveReason(raw *pg_query.RawStmt) string {
+  switch n := raw.GetStmt().GetNode().(type) {
+    case *pg_query.Node_DropStmt: {
+      return "DROP"
+    }
+
+    case *pg_query.Node_TruncateStmt: {
+      return "TRUNCATE"
+    }
+
+    case *pg_query.Node_AlterTableStmt: {
+      for _, cmd := range n.AlterTableStmt.GetCmds() {
+        if cmd.GetAlterTableCmd().GetSubtype() == pg_query.AlterTableType_AT_DropColumn {
+          return "ALTER TABLE ... DROP COLUMN"
+        }
+      }
+    }
+
+    case *pg_query.Node_DeleteStmt: {
+      if n.DeleteStmt.GetWhereClause() == nil {
+        return "DELETE without a WHERE clause"
+      }
+    }
+  }
+
+  return ""
+}
+
+func getDestructiveStmts(stmts []*migrationStmt) []string {
+  var destructive []string
+
+  for _, stmt := range stmts {
+    if reason := getDestructiveReason(stmt.raw); reason != "" {
+      destructive = append(destructive, fmt.Sprintf("%s:%d %s", stmt.file, stmt.line, reason))
+    }
+  }
+
+  return destructive
+}
+
+// Refuses to continue when a migration drops or deletes data, unless allowed
+// with --allow-destructive or an allow-destructive directive in the file.
+func checkForDestructiveMigrations(ctx *Context, migrations []string) error {
+  if ctx.AllowDestructive {
+    return nil
+  }
+
+  var destructive []string
+
+  for _, migration := range migrations {
+    if isGoMigration(ctx, migration) {
+      continue
+    }
+
+    code, err := readMigrationFile(ctx, migration)
+
+    if err != nil {
+      return err
+    }
+
+    if hasDirective(code, DIRECTIVE_ALLOW_DESTRUCTIVE) {
+      continue
+    }
+
+    stmts, err := getDialect(ctx).DestructiveStmts(migration, code)
+
+    if err != nil {
+      return &SyntaxError { migration, err }
+    }
+
+    destructive = append(destructive, stmts...)
+  }
+
+  if len(destructive) > 0 {
+    return &DestructiveMigrationsError { destructive }
+  }
+
+  return nil
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDestructiveStmts(t *testing.T) {
+  migration := `
+    DROP TABLE old_person;
+    TRUNCATE person;
+    ALTER TABLE person DROP COLUMN name;
+    ALTER TABLE person ADD COLUMN age integer;
+    DELETE FROM person;
+    DELETE FROM person WHERE id = 1;
+    DROP INDEX person_age_idx;
+  `
+
+  t.Run("destructive statements", func(t *testing.T) {
+    stmts, e := parseMigrationStmts("0001.sql", migration)
+    perr(e)
+
+    correct := []string{
+      "0001.sql:2 DROP",
+      "0001.sql:3 TRUNCATE",
+      "0001.sql:4 ALTER TABLE ... DROP COLUMN",
+      "0001.sql:6 DELETE without a WHERE clause",
+      "0001.sql:8 DROP",
+    }
+
+    checked := getDestructiveStmts(stmts)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
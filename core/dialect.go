@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Everything schemaflow needs to know about a database engine. Statements
+// are parsed into ParsedStmts with the dialect's own parser, so the rest of
+// make and migrate doesn't depend on the database being managed.
+type Dialect interface {
+  Name() string
+  // Opens a connection pool for db_ctx and checks that the database is reachable.
+  Open(ctx context.Context, db_ctx *DbContext) (*sql.DB, error)
+  // DDL creating the bookkeeping tables when they don't exist yet. It's
+  // expanded by bookkeepingSql.
+  MigrationSchema() string
+  // The name of a bookkeeping table in schema, e.g. schemaflow.migrations.
+  Table(schema string, name string) string
+  // Rewrites the $1, $2, ... placeholders used by schemaflow's queries for the driver.
+  Rebind(query string) string
+  // Splits code into statements, each deparsed into a canonical form with
+  // its name, type and the names of the statements it depends on.
+  ParseStmts(code string) ([]*ParsedStmt, error)
+  // Describes the statements in a migration file that drop or delete data,
+  // formatted as "file:line reason".
+  DestructiveStmts(file string, code string) ([]string, error)
+  // The DDL of every object in the database, read from its catalog. The
+  // bookkeeping tables are left out.
+  CatalogStmts(ctx *Context) ([]string, error)
+}
+
+const DIALECT_POSTGRES = "postgres"
+const DIALECT_MYSQL = "mysql"
+const DIALECT_SQLITE = "sqlite"
+
+var dialects = map[string]Dialect{
+  DIALECT_POSTGRES: postgresDialect{},
+  DIALECT_MYSQL: mysqlDialect{},
+  DIALECT_SQLITE: sqliteDialect{},
+}
+
+// Returns the dialect registered under name, e.g. "postgres", "mysql" or "sqlite".
+func GetDialect(name string) (Dialect, error) {
+  if dialect, ok := dialects[name]; ok {
+    return dialect, nil
+  }
+
+  return nil, fmt.Errorf("unknown dialect '%s'. Available dialects: %s", name, strings.Join(getDialectNames(), ", "))
+}
+
+func getDialectNames() []string {
+  var names []string
+
+  for name := range dialects {
+    names = append(names, name)
+  }
+
+  sort.Strings(names)
+  return names
+}
+
+// Returns ctx.Dialect, or postgres when it was not set.
+func getDialect(ctx *Context) Dialect {
+  if ctx == nil || ctx.Dialect == nil {
+    return postgresDialect{}
+  }
+
+  return ctx.Dialect
+}
+
+// Lint and the lock report understand postgres statements only.
+func requirePostgres(ctx *Context, feature string) error {
+  if name := getDialect(ctx).Name(); name != DIALECT_POSTGRES {
+    return fmt.Errorf("%s is only available for %s, not %s", feature, DIALECT_POSTGRES, name)
+  }
+
+  return nil
+}
+
+const DEFAULT_BOOKKEEPING_SCHEMA = "schemaflow"
+
+// Bookkeeping names are put into SQL as they are, so they're limited to
+// names that never need quoting.
+var bookkeepingName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+var bookkeepingTables = regexp.MustCompile(`\{(schema|migrations|statements|statements_hash_index)\}`)
+
+// Returns ctx.BookkeepingSchema, or DEFAULT_BOOKKEEPING_SCHEMA when it was not set.
+func getBookkeepingSchema(ctx *Context) string {
+  if ctx == nil || ctx.BookkeepingSchema == "" {
+    return DEFAULT_BOOKKEEPING_SCHEMA
+  }
+
+  return ctx.BookkeepingSchema
+}
+
+func validateBookkeepingNames(ctx *Context) error {
+  if schema := getBookkeepingSchema(ctx); !bookkeepingName.MatchString(schema) {
+    return fmt.Errorf("invalid bookkeeping schema '%s': use lower case letters, digits and underscores", schema)
+  }
+
+  if ctx.TablePrefix != "" && !bookkeepingName.MatchString(ctx.TablePrefix) {
+    return fmt.Errorf("invalid table prefix '%s': use lower case letters, digits and underscores", ctx.TablePrefix)
+  }
+
+  return nil
+}
+
+// Expands {schema}, {migrations} and {statements} in query to the
+// bookkeeping schema and the dialect's table names, and rebinds the
+// placeholders. {statements_hash_index} is the unqualified name of the index
+// on stmt_hash, the one postgres gives an unnamed index on it.
+func bookkeepingSql(ctx *Context, query string) string {
+  dialect := getDialect(ctx)
+  schema := getBookkeepingSchema(ctx)
+  prefix := ""
+
+  if ctx != nil {
+    prefix = ctx.TablePrefix
+  }
+
+  query = bookkeepingTables.ReplaceAllStringFunc(query, func(placeholder string) string {
+    name := strings.Trim(placeholder, "{}")
+
+    if name == "schema" {
+      return schema
+    }
+
+    if name == "statements_hash_index" {
+      return prefix + "statements_stmt_hash_idx"
+    }
+
+    return dialect.Table(schema, prefix + name)
+  })
+
+  return dialect.Rebind(query)
+}
+
+// Converts a byte offset in code to a line number.
+func lineOfOffset(code string, offset int) int {
+  return strings.Count(code[:min(max(offset, 0), len(code))], "\n") + 1
+}
@@ -0,0 +1,31 @@
+package core
+
+import "strings"
+
+// Directives are single line comments in a migration file that change how
+// schemaflow treats it, e.g. "-- schemaflow:squashed 0001.sql".
+const DIRECTIVE_PREFIX = "-- schemaflow:"
+
+const DIRECTIVE_SQUASHED = "squashed"
+
+func getDirectiveValues(code string, directive string) []string {
+  var values []string
+
+  prefix := DIRECTIVE_PREFIX + directive
+
+  for _, line := range strings.Split(code, "\n") {
+    line = strings.TrimSpace(line)
+
+    if line == prefix {
+      values = append(values, "")
+    } else if strings.HasPrefix(line, prefix + " ") {
+      values = append(values, strings.TrimSpace(strings.TrimPrefix(line, prefix)))
+    }
+  }
+
+  return values
+}
+
+func hasDirective(code string, directive string) bool {
+  return len(getDirectiveValues(code, directive)) > 0
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when a migration file still contains VALIDATE_MIGRATIONS_STRING.
+type UnresolvedMigrationsError struct {
+  Files []string
+}
+
+func (e *UnresolvedMigrationsError) Error() string {
+  return fmt.Sprintf("the following files have unresolved migrations: %s", strings.Join(e.Files, ", "))
+}
+
+// Returned when an executed migration was changed or deleted after it ran.
+type TamperedMigrationsError struct {
+  Files []string
+}
+
+func (e *TamperedMigrationsError) Error() string {
+  return fmt.Sprintf("the following executed migrations have been tampered with: %s", strings.Join(e.Files, ", "))
+}
+
+// Returned when a schema or migration file can't be parsed.
+type SyntaxError struct {
+  File string
+  Err error
+}
+
+func (e *SyntaxError) Error() string {
+  return fmt.Sprintf("syntax error in %s: %v", e.File, e.Err)
+}
+
+func (e *SyntaxError) Unwrap() error {
+  return e.Err
+}
+
+// Returned when a pending migration drops or deletes data and that was not allowed.
+type DestructiveMigrationsError struct {
+  Stmts []string
+}
+
+func (e *DestructiveMigrationsError) Error() string {
+  return fmt.Sprintf("the following migration statements are destructive: %s. Pass --allow-destructive or add '%s%s' to the file to run them", strings.Join(e.Stmts, ", "), DIRECTIVE_PREFIX, DIRECTIVE_ALLOW_DESTRUCTIVE)
+}
+
+// Returned when a squashed baseline replaces migrations that only partly ran on
+// the database, so that neither running the baseline nor recording it as
+// executed would leave the database right.
+type PartialBaselineError struct {
+  File string
+  Missing []string
+}
+
+func (e *PartialBaselineError) Error() string {
+  return fmt.Sprintf("%s replaces migrations that were only partially executed on this database. Missing: %s", e.File, strings.Join(e.Missing, ", "))
+}
+
+// Returned when a migration fails to execute.
+type MigrationError struct {
+  File string
+  Err error
+}
+
+func (e *MigrationError) Error() string {
+  return fmt.Sprintf("migration %s failed: %v", e.File, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+  return e.Err
+}
+
+// Returned by make when the role migrations run as can't create some new objects.
+type OwnerError struct {
+  Role string
+  Stmts []string
+}
+
+func (e *OwnerError) Error() string {
+  return fmt.Sprintf("role %s can't create the following new objects, so it would not own them: %s", e.Role, strings.Join(e.Stmts, ", "))
+}
+
+// Returned when schema statements depend on each other in a cycle, so no
+// order creates them all. Cycle starts and ends with the same statement.
+type DependencyCycleError struct {
+  Cycle []string
+  Suggestion string
+}
+
+func (e *DependencyCycleError) Error() string {
+  return fmt.Sprintf("dependency cycle, each statement depends on the next: %s. %s", strings.Join(e.Cycle, " -> "), e.Suggestion)
+}
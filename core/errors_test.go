@@ -0,0 +1,106 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUnresolvedMigrationsError(t *testing.T) {
+  t.Run("unresolved migrations error", func(t *testing.T) {
+    dir := t.TempDir()
+    unresolved := "0001.sql"
+
+    perr(os.WriteFile(filepath.Join(dir, "0000.sql"), []byte("create table a (id int);"), 0644))
+    perr(os.WriteFile(filepath.Join(dir, unresolved), []byte("/*\n" + VALIDATE_MIGRATIONS_STRING + "\n*/"), 0644))
+
+    err := checkForUnresolvedMigrations(&Context{ MigrationPath: dir })
+
+    var unresolved_err *UnresolvedMigrationsError
+
+    if !errors.As(err, &unresolved_err) {
+      test_failed(t, err, "UnresolvedMigrationsError")
+      return
+    }
+
+    if !reflect.DeepEqual(unresolved_err.Files, []string{ unresolved }) {
+      test_failed(t, unresolved_err.Files, []string{ unresolved })
+    }
+  })
+}
+
+func TestDestructiveMigrationsError(t *testing.T) {
+  t.Run("destructive migrations error", func(t *testing.T) {
+    allowed := "0000.sql"
+    refused := "0001.sql"
+
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      allowed: { Data: []byte("-- schemaflow:allow-destructive\ndrop table a;") },
+      refused: { Data: []byte("truncate b;") },
+    } }
+
+    err := checkForDestructiveMigrations(ctx, []string{ allowed, refused })
+
+    var destructive_err *DestructiveMigrationsError
+
+    if !errors.As(err, &destructive_err) {
+      test_failed(t, err, "DestructiveMigrationsError")
+      return
+    }
+
+    correct := []string{ refused + ":1 TRUNCATE" }
+
+    if !reflect.DeepEqual(destructive_err.Stmts, correct) {
+      test_failed(t, destructive_err.Stmts, correct)
+    }
+
+    ctx.AllowDestructive = true
+
+    if err := checkForDestructiveMigrations(ctx, []string{ allowed, refused }); err != nil {
+      test_failed(t, err, nil)
+    }
+  })
+}
+
+func TestMigrationFS(t *testing.T) {
+  t.Run("migration fs", func(t *testing.T) {
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      "0000.sql": { Data: []byte("create table a (id int);") },
+      "nested/0001.sql": { Data: []byte("create table b (id int);") },
+      "README.md": { Data: []byte("not a migration") },
+    } }
+
+    files, e := getMigrationFilesSorted(ctx)
+    perr(e)
+
+    correct := []string{ "0000.sql", "nested/0001.sql" }
+
+    if !reflect.DeepEqual(files, correct) {
+      test_failed(t, files, correct)
+    }
+
+    hash, e := hashMigrationFile(ctx, "nested/0001.sql")
+    perr(e)
+
+    if hash != HashString("create table b (id int);") {
+      test_failed(t, hash, HashString("create table b (id int);"))
+    }
+
+    if _, e := MakeMigrations(ctx); e == nil {
+      t.Errorf("make should refuse to write to an fs.FS")
+    }
+  })
+}
+
+func TestMissingMigrationPath(t *testing.T) {
+  t.Run("missing migration path", func(t *testing.T) {
+    files, e := getMigrationFilesSorted(&Context{ MigrationPath: filepath.Join(t.TempDir(), "missing") })
+
+    if e != nil || len(files) != 0 {
+      test_failed(t, files, "no files and no error")
+    }
+  })
+}
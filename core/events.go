@@ -0,0 +1,75 @@
+package core
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Receives schemaflow's progress messages as key value pairs. *slog.Logger
+// satisfies it.
+type Logger interface {
+  Debug(msg string, args ...any)
+  Info(msg string, args ...any)
+  Warn(msg string, args ...any)
+  Error(msg string, args ...any)
+}
+
+type EventType string
+
+const EVENT_FILE_PARSED EventType = "file_parsed"
+const EVENT_STATEMENT_PARSED EventType = "statement_parsed"
+const EVENT_MIGRATION_STARTED EventType = "migration_started"
+const EVENT_MIGRATION_FINISHED EventType = "migration_finished"
+const EVENT_MIGRATION_RECORDED EventType = "migration_recorded"
+const EVENT_MIGRATION_WRITTEN EventType = "migration_written"
+const EVENT_SQUASHED EventType = "squashed"
+const EVENT_DRIFT_FOUND EventType = "drift_found"
+const EVENT_LINT_FINDING EventType = "lint_finding"
+const EVENT_SNAPSHOT_WRITTEN EventType = "snapshot_written"
+
+// Kinds of drift reported by check in Event.Detail.
+const DRIFT_UNRESOLVED = "unresolved"
+const DRIFT_TAMPERED = "tampered"
+const DRIFT_SYNTAX_ERROR = "syntax_error"
+const DRIFT_SCHEMA_CHANGE = "schema_change"
+
+// One step of a run. Fields that don't apply to the event type are left empty.
+type Event struct {
+  Type EventType
+  // The schema, migration or snapshot file the event is about.
+  File string
+  // The deparsed statement, or the problem found for drift.
+  Stmt string
+  StmtName string
+  // The drift kind, lint rule, or number of statements written.
+  Detail string
+  // Line of the statement in File, when known.
+  Line int
+  // Time spent executing a migration, set on EVENT_MIGRATION_FINISHED.
+  Duration time.Duration
+  // Set on EVENT_MIGRATION_FINISHED when the migration failed.
+  Err error
+  // Set by MigrateDatabases and MigrateSchemas to the database or schema the
+  // event is about.
+  Database string
+  Schema string
+}
+
+// Called synchronously for every event, so it should return quickly.
+// MigrateDatabases and MigrateSchemas never call it concurrently.
+type Observer func(event Event)
+
+// Returns ctx.Logger, or slog.Default() when it was not set.
+func getLogger(ctx *Context) Logger {
+  if ctx == nil || ctx.Logger == nil {
+    return slog.Default()
+  }
+
+  return ctx.Logger
+}
+
+func emit(ctx *Context, event Event) {
+  if ctx != nil && ctx.Observer != nil {
+    ctx.Observer(event)
+  }
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDriftEvents(t *testing.T) {
+  t.Run("drift events", func(t *testing.T) {
+    var events []Event
+    var buf bytes.Buffer
+
+    ctx := &Context{
+      Logger: slog.New(slog.NewJSONHandler(&buf, nil)),
+      Observer: func(event Event) { events = append(events, event) },
+    }
+
+    reportCheckFailure(ctx, "Executed migration has been tampered with", DRIFT_TAMPERED, []string{ "0001.sql" })
+    reportCheckFailure(ctx, "Change without a migration", DRIFT_SCHEMA_CHANGE, []string{ "new: CREATE TABLE a (id int)" })
+
+    correct := []Event{
+      { Type: EVENT_DRIFT_FOUND, File: "0001.sql", Detail: DRIFT_TAMPERED },
+      { Type: EVENT_DRIFT_FOUND, Stmt: "new: CREATE TABLE a (id int)", Detail: DRIFT_SCHEMA_CHANGE },
+    }
+
+    if !reflect.DeepEqual(events, correct) {
+      test_failed(t, events, correct)
+    }
+
+    if !strings.Contains(buf.String(), `"drift":"tampered","item":"0001.sql"`) {
+      test_failed(t, buf.String(), `"drift":"tampered","item":"0001.sql"`)
+    }
+  })
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// How pg_query's plpgsql parser says the query of a PLpgSQL_expr is parsed,
+// RawParseMode in postgres.
+const (
+  PLPGSQL_PARSE_DEFAULT = 0
+  PLPGSQL_PARSE_TYPE_NAME = 1
+  PLPGSQL_PARSE_EXPR = 2
+  PLPGSQL_PARSE_ASSIGN1 = 3
+  PLPGSQL_PARSE_ASSIGN2 = 4
+  PLPGSQL_PARSE_ASSIGN3 = 5
+)
+
+// Returns the value of the option named name of a CREATE FUNCTION, e.g.
+// "language" or "as".
+func getFunctionOption(fn *pg_query.CreateFunctionStmt, name string) *pg_query.Node {
+  for _, option := range fn.GetOptions() {
+    if def := option.GetDefElem(); def != nil && def.GetDefname() == name {
+      return def.GetArg()
+    }
+  }
+
+  return nil
+}
+
+// Adds the dependencies of the statements in code to ps. Code that doesn't
+// parse, like an EXECUTE string built at run time, is skipped.
+func appendSqlDependencies(ps *ParsedStmt, code string, soft bool) {
+  parsed, err := parseSql(code)
+
+  if err != nil {
+    return
+  }
+
+  for _, raw := range parsed.GetStmts() {
+    appendNodeDependencies(ps, raw.GetStmt(), soft)
+  }
+}
+
+// Adds the dependencies of node to ps without changing its name or type.
+func appendNodeDependencies(ps *ParsedStmt, node *pg_query.Node, soft bool) {
+  scratch := &ParsedStmt{ Dependencies: make([]*Dependency, 0) }
+  hydrateStmtObject(node, scratch)
+
+  for _, dep := range scratch.Dependencies {
+    before := len(ps.Dependencies)
+    appendDependency(ps, dep.StmtType, dep.StmtName)
+
+    if soft && len(ps.Dependencies) > before {
+      ps.Dependencies[before].Soft = true
+    }
+  }
+}
+
+// Collects the queries and type names in the JSON of pg_query's plpgsql
+// parser as SQL statements.
+func collectPlpgsqlStmts(node any, stmts *[]string) {
+  switch n := node.(type) {
+    case []any: {
+      for _, item := range n {
+        collectPlpgsqlStmts(item, stmts)
+      }
+    }
+
+    case map[string]any: {
+      if expr, ok := n["PLpgSQL_expr"].(map[string]any); ok {
+        query, _ := expr["query"].(string)
+        mode, _ := expr["parseMode"].(float64)
+
+        switch int(mode) {
+          case PLPGSQL_PARSE_DEFAULT: {
+            *stmts = append(*stmts, query)
+          }
+
+          case PLPGSQL_PARSE_TYPE_NAME: {
+            *stmts = append(*stmts, "SELECT NULL::" + query)
+          }
+
+          case PLPGSQL_PARSE_EXPR: {
+            *stmts = append(*stmts, "SELECT " + query)
+          }
+
+          case PLPGSQL_PARSE_ASSIGN1, PLPGSQL_PARSE_ASSIGN2, PLPGSQL_PARSE_ASSIGN3: {
+            _, value, ok := strings.Cut(query, ":=")
+
+            if !ok {
+              _, value, _ = strings.Cut(query, "=")
+            }
+
+            *stmts = append(*stmts, "SELECT " + value)
+          }
+        }
+
+        return
+      }
+
+      if datatype, ok := n["PLpgSQL_type"].(map[string]any); ok {
+        type_name, _ := datatype["typname"].(string)
+        type_name = strings.TrimSpace(type_name)
+
+        // Parameters, whose types are dependencies of the function already.
+        if type_name == "UNKNOWN" {
+          return
+        }
+
+        // person%rowtype and person.name%type refer to the table.
+        if table, _, ok := strings.Cut(type_name, "%"); ok {
+          if strings.HasSuffix(strings.ToLower(type_name), "%type") {
+            if i := strings.LastIndex(table, "."); i >= 0 {
+              table = table[:i]
+            }
+          }
+
+          *stmts = append(*stmts, "SELECT * FROM " + table)
+          return
+        }
+
+        *stmts = append(*stmts, "SELECT NULL::" + type_name)
+        return
+      }
+
+      // Sorted so that dependencies come out in the same order every time.
+      keys := make([]string, 0, len(n))
+
+      for key := range n {
+        keys = append(keys, key)
+      }
+
+      sort.Strings(keys)
+
+      for _, key := range keys {
+        collectPlpgsqlStmts(n[key], stmts)
+      }
+    }
+  }
+}
+
+// Adds the tables, functions and types the body of fn uses to ps. SQL bodies
+// are checked when the function is created, plpgsql bodies only when they
+// run, so dependencies found in plpgsql are soft.
+func hydrateFunctionBody(fn *pg_query.CreateFunctionStmt, ps *ParsedStmt) {
+  // BEGIN ATOMIC ... END and RETURN bodies are parsed along with the function.
+  if sql_body := fn.GetSqlBody(); sql_body != nil {
+    appendNodeDependencies(ps, sql_body, false)
+    return
+  }
+
+  language := strings.ToLower(getFunctionOption(fn, "language").GetString_().GetSval())
+  body_items := getFunctionOption(fn, "as").GetList().GetItems()
+
+  if len(body_items) == 0 {
+    return
+  }
+
+  switch language {
+    case "sql": {
+      appendSqlDependencies(ps, body_items[0].GetString_().GetSval(), false)
+    }
+
+    case "plpgsql": {
+      code, err := deparseRawStmt(&pg_query.RawStmt{ Stmt: &pg_query.Node{ Node: &pg_query.Node_CreateFunctionStmt{ CreateFunctionStmt: fn } } })
+
+      if err != nil {
+        return
+      }
+
+      parsed, err := pg_query.ParsePlPgSqlToJSON(code)
+
+      if err != nil {
+        return
+      }
+
+      var functions any
+
+      if err := json.Unmarshal([]byte(parsed), &functions); err != nil {
+        return
+      }
+
+      var stmts []string
+      collectPlpgsqlStmts(functions, &stmts)
+
+      for _, stmt := range stmts {
+        appendSqlDependencies(ps, stmt, true)
+      }
+    }
+  }
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A migration written in Go. It runs in the same transaction as the SQL
+// migrations and must not commit or roll back the transaction itself. ctx is
+// cancelled when the run is, and should be passed to every query.
+type GoMigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+const GO_MIGRATION_SUFFIX = ".go"
+
+var goMigrations = make(map[string]GoMigrationFunc)
+
+// RegisterMigration ties fn to a migration version such as "0005_backfill_ages".
+// It is ordered by name alongside the migration files, so it runs after
+// 0005.sql and before 0006.sql, and is recorded as "0005_backfill_ages.go".
+// Register every Go migration before calling Migrate, usually from init().
+// A Go migration can't be squashed, and one numbered at or below a squashed
+// baseline is refused, since it would run before the baseline on new databases.
+func RegisterMigration(version string, fn GoMigrationFunc) {
+  name := goMigrationName(version)
+
+  if _, ok := goMigrations[name]; ok {
+    panic(fmt.Sprintf("schemaflow: migration %s registered twice", name))
+  }
+
+  goMigrations[name] = fn
+}
+
+func goMigrationName(version string) string {
+  return strings.TrimSuffix(version, GO_MIGRATION_SUFFIX) + GO_MIGRATION_SUFFIX
+}
+
+// The Go migrations ctx runs by name: ctx.GoMigrations, or the registered
+// ones when it isn't set.
+func getGoMigrations(ctx *Context) map[string]GoMigrationFunc {
+  if ctx == nil || ctx.GoMigrations == nil {
+    return goMigrations
+  }
+
+  migrations := make(map[string]GoMigrationFunc)
+
+  for version, fn := range ctx.GoMigrations {
+    migrations[goMigrationName(version)] = fn
+  }
+
+  return migrations
+}
+
+func isGoMigration(ctx *Context, migration string) bool {
+  _, ok := getGoMigrations(ctx)[extractFileFromPath(migration)]
+  return ok
+}
+
+// Go migrations are recorded under their name, so an executed migration is
+// known to be one even when this process didn't register it, like the CLI.
+func isGoMigrationName(migration string) bool {
+  return strings.HasSuffix(migration, GO_MIGRATION_SUFFIX)
+}
+
+func getGoMigrationsSorted(ctx *Context) []string {
+  var names []string
+
+  for name := range getGoMigrations(ctx) {
+    names = append(names, name)
+  }
+
+  sort.Strings(names)
+  return names
+}
+
+// Refuses pending Go migrations numbered at or below a squashed baseline. They
+// were registered for migrations that the baseline replaced, so they would
+// run before the tables they need exist.
+func checkGoMigrationsAfterBaselines(ctx *Context, pending []string) error {
+  var go_pending []string
+
+  for _, migration := range pending {
+    if isGoMigration(ctx, migration) {
+      go_pending = append(go_pending, migration)
+    }
+  }
+
+  if len(go_pending) == 0 {
+    return nil
+  }
+
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return err
+  }
+
+  for _, file := range files {
+    squashed, err := getSquashedFiles(ctx, file)
+
+    if err != nil {
+      return err
+    }
+
+    baseline_number, ok := migrationNumber(file)
+
+    if len(squashed) == 0 || !ok {
+      continue
+    }
+
+    for _, migration := range go_pending {
+      if number, ok := migrationNumber(migration); ok && number <= baseline_number {
+        return fmt.Errorf("Go migration %s is numbered at or below the baseline %s and would run before it. Register it with a number after %04d", extractFileFromPath(migration), extractFileFromPath(file), baseline_number)
+      }
+    }
+  }
+
+  return nil
+}
+
+func executeGoMigration(ctx *Context, migration string) error {
+  name := extractFileFromPath(migration)
+
+  if err := setRole(ctx, ctx.Role); err != nil {
+    return &MigrationError { name, err }
+  }
+
+  if err := getGoMigrations(ctx)[name](getCtx(ctx), ctx.DbTx); err != nil {
+    return &MigrationError { name, err }
+  }
+
+  if err := resetRole(ctx, ctx.Role); err != nil {
+    return err
+  }
+
+  return insertExecutedMigration(ctx, name, HashString(name))
+}
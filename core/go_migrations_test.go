@@ -0,0 +1,171 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoMigrationOrder(t *testing.T) {
+  t.Run("go migration order", func(t *testing.T) {
+    dir := t.TempDir()
+
+    for _, name := range []string{ "0000.sql", "0001.sql" } {
+      perr(os.WriteFile(filepath.Join(dir, name), []byte("select 1;"), 0644))
+    }
+
+    ctx := &Context{
+      MigrationPath: dir,
+      GoMigrations: map[string]GoMigrationFunc{
+        "0000_backfill": func(ctx context.Context, tx *sql.Tx) error { return nil },
+      },
+    }
+
+    migrations, e := getMigrationsSorted(ctx)
+    perr(e)
+
+    var checked []string
+
+    for _, migration := range migrations {
+      checked = append(checked, extractFileFromPath(migration))
+    }
+
+    correct := []string{ "0000.sql", "0000_backfill.go", "0001.sql" }
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+
+    if !isGoMigration(ctx, "0000_backfill.go") || isGoMigration(ctx, filepath.Join(dir, "0000.sql")) {
+      t.Errorf("isGoMigration does not match the registered migrations")
+    }
+
+    if isGoMigration(&Context{ GoMigrations: map[string]GoMigrationFunc{} }, "0000_backfill.go") {
+      t.Errorf("isGoMigration found a migration that isn't registered")
+    }
+  })
+
+  t.Run("go migration before a baseline", func(t *testing.T) {
+    dir := t.TempDir()
+    perr(os.WriteFile(filepath.Join(dir, "0001_baseline.sql"), []byte("-- schemaflow:squashed 0000.sql\n-- schemaflow:squashed 0001.sql\nselect 1;"), 0644))
+
+    backfill := func(ctx context.Context, tx *sql.Tx) error { return nil }
+    ctx := &Context{ MigrationPath: dir, GoMigrations: map[string]GoMigrationFunc{ "0001_backfill": backfill } }
+
+    migrations, e := getMigrationsSorted(ctx)
+    perr(e)
+
+    if e := checkGoMigrationsAfterBaselines(ctx, migrations); e == nil {
+      t.Errorf("expected an error for a Go migration before the baseline")
+    }
+
+    ctx.GoMigrations = map[string]GoMigrationFunc{ "0002_backfill": backfill }
+
+    migrations, e = getMigrationsSorted(ctx)
+    perr(e)
+    perr(checkGoMigrationsAfterBaselines(ctx, migrations))
+  })
+}
+
+// A binary that registers no Go migrations, like the CLI, runs against a
+// database where an application recorded one.
+func TestUnregisteredGoMigration(t *testing.T) {
+  t.Run("unregistered go migration", func(t *testing.T) {
+    dir := t.TempDir()
+    migration_path := filepath.Join(dir, "migrations")
+    perr(os.MkdirAll(migration_path, 0755))
+    perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte("CREATE TABLE person (id integer primary key, age integer);\n"), 0644))
+
+    migrate := func(go_migrations map[string]GoMigrationFunc) *MigrateResult {
+      ctx := &Context{
+        DbContext: &DbContext{ PgDbName: filepath.Join(dir, "test.db") },
+        Dialect: sqliteDialect{},
+        MigrationPath: migration_path,
+        Action: MIGRATE,
+        GoMigrations: go_migrations,
+      }
+
+      result, e := migrateTarget(ctx)
+      perr(e)
+
+      return result
+    }
+
+    backfill := func(ctx context.Context, tx *sql.Tx) error {
+      _, err := tx.ExecContext(ctx, "insert into person (age) values (1)")
+      return err
+    }
+
+    result := migrate(map[string]GoMigrationFunc{ "0000_backfill": backfill })
+    correct := []string{ "0000.sql", "0000_backfill.go" }
+
+    if !reflect.DeepEqual(result.Executed, correct) {
+      test_failed(t, result.Executed, correct)
+    }
+
+    result = migrate(map[string]GoMigrationFunc{})
+
+    if len(result.Executed) != 0 {
+      test_failed(t, result.Executed, "nothing executed")
+    }
+  })
+}
+
+// Cancelling ctx.Ctx in the middle of a migration stops it and rolls back
+// everything it did, so nothing is recorded as applied.
+func TestCancelledMigration(t *testing.T) {
+  t.Run("cancelled migration", func(t *testing.T) {
+    dir := t.TempDir()
+    db_path := filepath.Join(dir, "test.db")
+    migration_path := filepath.Join(dir, "migrations")
+    perr(os.MkdirAll(migration_path, 0755))
+    perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte("CREATE TABLE person (id integer primary key);\n"), 0644))
+    perr(os.WriteFile(filepath.Join(migration_path, "0001.sql"), []byte("CREATE TABLE pet (id integer primary key);\n"), 0644))
+
+    cancellable, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    ctx := &Context{
+      Ctx: cancellable,
+      DbContext: &DbContext{ PgDbName: db_path },
+      Dialect: sqliteDialect{},
+      MigrationPath: migration_path,
+      Action: MIGRATE,
+      GoMigrations: map[string]GoMigrationFunc{
+        "0000_interrupt": func(ctx context.Context, tx *sql.Tx) error {
+          cancel()
+          return nil
+        },
+      },
+    }
+
+    if _, e := migrateTarget(ctx); !errors.Is(e, context.Canceled) {
+      test_failed(t, e, context.Canceled)
+    }
+
+    db, e := sql.Open("sqlite3", db_path)
+    perr(e)
+    defer db.Close()
+
+    var tables []string
+    rows, e := db.Query("select name from sqlite_master where type = 'table' order by name")
+    perr(e)
+
+    for rows.Next() {
+      var name string
+      perr(rows.Scan(&name))
+      tables = append(tables, name)
+    }
+
+    perr(rows.Err())
+    rows.Close()
+
+    if len(tables) != 0 {
+      test_failed(t, tables, []string{})
+    }
+  })
+}
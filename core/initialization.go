@@ -4,15 +4,34 @@ import "os"
 
 const MIGRATION_SCHEMA = `
 
-create schema if not exists schemaflow;
+create schema if not exists {schema};
 
-create table if not exists schemaflow.migrations (
-  file_name text primary key not null,
+create table if not exists {migrations} (
+  file_name text not null,
   file_hash text not null,
-  created timestamp default now()
+  created timestamp default now(),
+  schema_name text not null default '',
+  primary key (file_name, schema_name)
 );
 
-create table if not exists schemaflow.statements (
+do $$
+begin
+  if not exists (
+    select from pg_attribute
+    where attrelid = '{migrations}'::regclass and attname = 'schema_name' and not attisdropped
+  ) then
+    alter table {migrations} add column schema_name text not null default '';
+    execute (
+      select format('alter table {migrations} drop constraint %I', conname)
+      from pg_constraint
+      where conrelid = '{migrations}'::regclass and contype = 'p'
+    );
+    alter table {migrations} add primary key (file_name, schema_name);
+  end if;
+end
+$$;
+
+create table if not exists {statements} (
   id serial primary key,
   stmt text not null,
   stmt_hash text unique not null,
@@ -22,21 +41,73 @@ create table if not exists schemaflow.statements (
   updated timestamp default now()
 );
 
-create index on schemaflow.statements(stmt_hash);
+create index if not exists {statements_hash_index} on {statements}(stmt_hash);
+
+-- Earlier versions added another unnamed index on stmt_hash on every run.
+do $$
+declare
+  duplicate text;
+begin
+  for duplicate in
+    select format('%I.%I', n.nspname, c.relname)
+    from pg_index i
+    join pg_class c on c.oid = i.indexrelid
+    join pg_namespace n on n.oid = c.relnamespace
+    where i.indrelid = '{statements}'::regclass and c.relname ~ '^{statements_hash_index}[0-9]+$'
+  loop
+    execute 'drop index ' || duplicate;
+  end loop;
+end
+$$;
 `
 
-func initializeMigrationsSchema(ctx *Context) {
-  _, err := ctx.Db.Exec(MIGRATION_SCHEMA)
-  perr(err)
+func initializeMigrationsSchema(ctx *Context) error {
+  if err := validateBookkeepingNames(ctx); err != nil {
+    return err
+  }
+
+  _, err := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, getDialect(ctx).MigrationSchema()))
+  return err
 }
 
-func initializeMigrationsFolder(ctx *Context) {
+func initializeMigrationsFolder(ctx *Context) error {
+  if ctx.MigrationFS != nil {
+    return nil
+  }
+
   if !DoesPathExist(ctx.MigrationPath) {
-    os.MkdirAll(ctx.MigrationPath, 0755)
+    return os.MkdirAll(ctx.MigrationPath, 0755)
   }
+
+  return nil
 }
 
-func Initialize(ctx *Context) {
-  initializeMigrationsFolder(ctx)
-  initializeMigrationsSchema(ctx)
+// Read only actions must not leave anything behind. Callers roll back their
+// transaction instead of committing it.
+func IsReadOnly(ctx *Context) bool {
+  return ctx.DryRun || ctx.Action == CHECK || ctx.Action == LINT || ctx.Action == STATUS
+}
+
+func Initialize(ctx *Context) error {
+  // The schema is created inside the transaction, so only the folder needs skipping.
+  if !IsReadOnly(ctx) {
+    if err := initializeMigrationsFolder(ctx); err != nil {
+      return err
+    }
+  }
+
+  // MySQL commits DDL even inside a transaction, so read only actions only
+  // check for the tables there. Missing ones read as empty.
+  if IsReadOnly(ctx) && getDialect(ctx).Name() == DIALECT_MYSQL {
+    if err := validateBookkeepingNames(ctx); err != nil {
+      return err
+    }
+
+    created, err := isMysqlBookkeepingCreated(ctx)
+    ctx.bookkeepingMissing = !created
+
+    return err
+  }
+
+  return initializeMigrationsSchema(ctx)
 }
@@ -0,0 +1,313 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+const DIRECTIVE_LINT_IGNORE = "lint-ignore"
+
+const (
+  LINT_NOT_NULL_WITHOUT_DEFAULT = "not-null-without-default"
+  LINT_INDEX_NOT_CONCURRENT = "index-not-concurrent"
+  LINT_COLUMN_TYPE_CHANGE = "column-type-change"
+  LINT_DROP_COLUMN = "drop-column"
+  LINT_DROP_TABLE = "drop-table"
+  LINT_FOREIGN_KEY_NOT_VALID = "foreign-key-not-valid"
+  LINT_VACUUM_FULL = "vacuum-full"
+  LINT_OUTSIDE_TRANSACTION = "outside-transaction"
+)
+
+var LINT_RULES = []string{
+  LINT_NOT_NULL_WITHOUT_DEFAULT,
+  LINT_INDEX_NOT_CONCURRENT,
+  LINT_COLUMN_TYPE_CHANGE,
+  LINT_DROP_COLUMN,
+  LINT_DROP_TABLE,
+  LINT_FOREIGN_KEY_NOT_VALID,
+  LINT_VACUUM_FULL,
+  LINT_OUTSIDE_TRANSACTION,
+}
+
+type lintFinding struct {
+  file string
+  line int
+  rule string
+  message string
+}
+
+// A single statement of a migration file along with where it came from.
+type migrationStmt struct {
+  file string
+  line int
+  source string
+  raw *pg_query.RawStmt
+}
+
+func parseMigrationStmts(file string, code string) ([]*migrationStmt, error) {
+  var stmts []*migrationStmt
+
+  parsed, err := parseSql(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, raw := range parsed.GetStmts() {
+    stmts = append(stmts, &migrationStmt { file, rawStmtLine(code, raw), rawStmtSource(code, raw), raw })
+  }
+
+  return stmts, nil
+}
+
+func isLintRuleIgnored(stmt *migrationStmt, rule string) bool {
+  for _, value := range getDirectiveValues(stmt.source, DIRECTIVE_LINT_IGNORE) {
+    if value == "" {
+      return true
+    }
+
+    for _, ignored := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+      if ignored == rule {
+        return true
+      }
+    }
+  }
+
+  return false
+}
+
+func hasConstraint(constraints []*pg_query.Node, types ...pg_query.ConstrType) bool {
+  for _, c := range constraints {
+    for _, t := range types {
+      if c.GetConstraint().GetContype() == t {
+        return true
+      }
+    }
+  }
+
+  return false
+}
+
+// Tables created by the migration itself are empty, so most checks do not apply to them.
+func getTablesCreatedInMigration(stmts []*migrationStmt) map[string]bool {
+  created := make(map[string]bool)
+
+  for _, stmt := range stmts {
+    if cs := stmt.raw.GetStmt().GetCreateStmt(); cs != nil {
+      created[pgRangevarToString(cs.GetRelation())] = true
+    }
+  }
+
+  return created
+}
+
+func lintAlterTableCmd(table string, cmd *pg_query.AlterTableCmd, add func(string, string)) {
+  switch cmd.GetSubtype() {
+    case pg_query.AlterTableType_AT_AddColumn: {
+      cd := cmd.GetDef().GetColumnDef()
+      constraints := cd.GetConstraints()
+
+      if hasConstraint(constraints, pg_query.ConstrType_CONSTR_NOTNULL) && !hasConstraint(constraints, pg_query.ConstrType_CONSTR_DEFAULT, pg_query.ConstrType_CONSTR_IDENTITY, pg_query.ConstrType_CONSTR_GENERATED) {
+        add(LINT_NOT_NULL_WITHOUT_DEFAULT, fmt.Sprintf("adding NOT NULL column %s to %s without a default fails on a non-empty table", cd.GetColname(), table))
+      }
+    }
+
+    case pg_query.AlterTableType_AT_AlterColumnType: {
+      add(LINT_COLUMN_TYPE_CHANGE, fmt.Sprintf("changing the type of %s.%s may rewrite the table and its indexes", table, cmd.GetName()))
+    }
+
+    case pg_query.AlterTableType_AT_DropColumn: {
+      add(LINT_DROP_COLUMN, fmt.Sprintf("dropping column %s.%s breaks code that still reads it", table, cmd.GetName()))
+    }
+
+    case pg_query.AlterTableType_AT_AddConstraint: {
+      c := cmd.GetDef().GetConstraint()
+
+      if c.GetContype() == pg_query.ConstrType_CONSTR_FOREIGN && !c.GetSkipValidation() {
+        add(LINT_FOREIGN_KEY_NOT_VALID, fmt.Sprintf("adding a foreign key to %s without NOT VALID scans the table while holding a lock", table))
+      }
+    }
+  }
+}
+
+func lintMigrationStmts(stmts []*migrationStmt, disabled map[string]bool) []lintFinding {
+  var findings []lintFinding
+
+  created := getTablesCreatedInMigration(stmts)
+
+  for _, stmt := range stmts {
+    add := func(rule string, message string) {
+      if disabled[rule] || isLintRuleIgnored(stmt, rule) {
+        return
+      }
+
+      findings = append(findings, lintFinding { stmt.file, stmt.line, rule, message })
+    }
+
+    if what := getOutsideTransactionStmt(stmt.raw); what != "" {
+      add(LINT_OUTSIDE_TRANSACTION, fmt.Sprintf("%s can't run inside a transaction, and migrate runs every migration in one", what))
+    }
+
+    switch n := stmt.raw.GetStmt().GetNode().(type) {
+      case *pg_query.Node_AlterTableStmt: {
+        table := pgRangevarToString(n.AlterTableStmt.GetRelation())
+
+        for _, cmd := range n.AlterTableStmt.GetCmds() {
+          if created[table] && cmd.GetAlterTableCmd().GetSubtype() != pg_query.AlterTableType_AT_DropColumn {
+            continue
+          }
+
+          lintAlterTableCmd(table, cmd.GetAlterTableCmd(), add)
+        }
+      }
+
+      case *pg_query.Node_IndexStmt: {
+        table := pgRangevarToString(n.IndexStmt.GetRelation())
+
+        if !n.IndexStmt.GetConcurrent() && !created[table] {
+          add(LINT_INDEX_NOT_CONCURRENT, fmt.Sprintf("CREATE INDEX blocks writes to %s while the index builds. CONCURRENTLY can't be used in the transaction migrate runs in, so build a large index by hand beforehand and make this one IF NOT EXISTS", table))
+        }
+      }
+
+      case *pg_query.Node_DropStmt: {
+        if n.DropStmt.GetRemoveType() == pg_query.ObjectType_OBJECT_TABLE {
+          for _, object := range n.DropStmt.GetObjects() {
+            add(LINT_DROP_TABLE, fmt.Sprintf("dropping table %s deletes its data", pgListToString(object.GetList())))
+          }
+        }
+      }
+
+      case *pg_query.Node_VacuumStmt: {
+        for _, option := range n.VacuumStmt.GetOptions() {
+          if n.VacuumStmt.GetIsVacuumcmd() && option.GetDefElem().GetDefname() == "full" {
+            add(LINT_VACUUM_FULL, "VACUUM FULL rewrites the table while holding an ACCESS EXCLUSIVE lock. Run it by hand, outside of migrate")
+          }
+        }
+      }
+    }
+  }
+
+  return findings
+}
+
+// The kind of statement raw is when postgres refuses to run it inside a
+// transaction block, or "".
+func getOutsideTransactionStmt(raw *pg_query.RawStmt) string {
+  switch n := raw.GetStmt().GetNode().(type) {
+    case *pg_query.Node_IndexStmt: {
+      if n.IndexStmt.GetConcurrent() {
+        return "CREATE INDEX CONCURRENTLY"
+      }
+    }
+
+    case *pg_query.Node_DropStmt: {
+      if n.DropStmt.GetConcurrent() {
+        return "DROP INDEX CONCURRENTLY"
+      }
+    }
+
+    case *pg_query.Node_ReindexStmt: {
+      for _, param := range n.ReindexStmt.GetParams() {
+        if param.GetDefElem().GetDefname() == "concurrently" {
+          return "REINDEX CONCURRENTLY"
+        }
+      }
+    }
+
+    case *pg_query.Node_VacuumStmt: {
+      if n.VacuumStmt.GetIsVacuumcmd() {
+        return "VACUUM"
+      }
+    }
+
+    case *pg_query.Node_CreatedbStmt: {
+      return "CREATE DATABASE"
+    }
+
+    case *pg_query.Node_DropdbStmt: {
+      return "DROP DATABASE"
+    }
+
+    case *pg_query.Node_CreateTableSpaceStmt: {
+      return "CREATE TABLESPACE"
+    }
+
+    case *pg_query.Node_DropTableSpaceStmt: {
+      return "DROP TABLESPACE"
+    }
+
+    case *pg_query.Node_AlterSystemStmt: {
+      return "ALTER SYSTEM"
+    }
+  }
+
+  return ""
+}
+
+func getDisabledLintRules(ctx *Context) map[string]bool {
+  disabled := make(map[string]bool)
+
+  for _, rule := range ctx.LintDisabled {
+    disabled[rule] = true
+  }
+
+  return disabled
+}
+
+// Lint reports risky statements in every unexecuted migration. It returns
+// false when anything was found.
+func Lint(ctx *Context) (bool, error) {
+  if err := requirePostgres(ctx, "lint"); err != nil {
+    return false, err
+  }
+
+  passed := true
+  disabled := getDisabledLintRules(ctx)
+
+  var all []*migrationStmt
+
+  files, err := getListOfUnexecutedMigrations(ctx)
+
+  if err != nil {
+    return false, err
+  }
+
+  for _, file := range files {
+    if isGoMigration(ctx, file) {
+      continue
+    }
+
+    code, err := readMigrationFile(ctx, file)
+
+    if err != nil {
+      return false, err
+    }
+
+    stmts, err := parseMigrationStmts(file, code)
+
+    if err != nil {
+      getLogger(ctx).Error("Migration fails to parse", "file", file, "error", err)
+      passed = false
+      continue
+    }
+
+    for _, finding := range lintMigrationStmts(stmts, disabled) {
+      getLogger(ctx).Warn(finding.message, "file", finding.file, "line", finding.line, "rule", finding.rule)
+      emit(ctx, Event { Type: EVENT_LINT_FINDING, File: finding.file, Line: finding.line, Detail: finding.rule, Stmt: finding.message })
+      passed = false
+    }
+
+    all = append(all, stmts...)
+  }
+
+  if len(all) > 0 {
+    if err := writeLockReport(os.Stdout, all); err != nil {
+      return false, err
+    }
+  }
+
+  return passed, nil
+}
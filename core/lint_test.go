@@ -0,0 +1,147 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func lintRulesFound(code string, disabled map[string]bool) []string {
+  stmts, e := parseMigrationStmts("0001.sql", code)
+  perr(e)
+
+  var rules []string
+
+  for _, finding := range lintMigrationStmts(stmts, disabled) {
+    rules = append(rules, finding.rule)
+  }
+
+  return rules
+}
+
+func TestLintRules(t *testing.T) {
+  migration := `
+    ALTER TABLE person ADD COLUMN age integer NOT NULL;
+    ALTER TABLE person ADD COLUMN created timestamp NOT NULL DEFAULT now();
+    CREATE INDEX person_age_idx ON person (age);
+    CREATE INDEX CONCURRENTLY person_created_idx ON person (created);
+    ALTER TABLE person ALTER COLUMN age TYPE bigint;
+    ALTER TABLE person DROP COLUMN name;
+    DROP TABLE old_person;
+    ALTER TABLE person ADD CONSTRAINT person_parent_fk FOREIGN KEY (parent_id) REFERENCES person (id);
+    ALTER TABLE person ADD CONSTRAINT person_other_fk FOREIGN KEY (other_id) REFERENCES person (id) NOT VALID;
+    VACUUM FULL person;
+    VACUUM person;
+  `
+
+  t.Run("lint rules", func(t *testing.T) {
+    correct := []string{
+      LINT_NOT_NULL_WITHOUT_DEFAULT,
+      LINT_INDEX_NOT_CONCURRENT,
+      LINT_OUTSIDE_TRANSACTION,
+      LINT_COLUMN_TYPE_CHANGE,
+      LINT_DROP_COLUMN,
+      LINT_DROP_TABLE,
+      LINT_FOREIGN_KEY_NOT_VALID,
+      LINT_OUTSIDE_TRANSACTION,
+      LINT_VACUUM_FULL,
+      LINT_OUTSIDE_TRANSACTION,
+    }
+
+    checked := lintRulesFound(migration, nil)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestLintOutsideTransaction(t *testing.T) {
+  migration := `
+    CREATE INDEX CONCURRENTLY person_age_idx ON person (age);
+    DROP INDEX CONCURRENTLY person_name_idx;
+    REINDEX (CONCURRENTLY) TABLE person;
+    REINDEX TABLE person;
+    VACUUM ANALYZE person;
+    ANALYZE person;
+    CREATE DATABASE reports;
+  `
+
+  t.Run("statements migrate can't run", func(t *testing.T) {
+    correct := []string{ LINT_OUTSIDE_TRANSACTION, LINT_OUTSIDE_TRANSACTION, LINT_OUTSIDE_TRANSACTION, LINT_OUTSIDE_TRANSACTION, LINT_OUTSIDE_TRANSACTION }
+    checked := lintRulesFound(migration, nil)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestLintIgnore(t *testing.T) {
+  migration := `
+    -- schemaflow:lint-ignore drop-column
+    ALTER TABLE person DROP COLUMN name;
+
+    -- schemaflow:lint-ignore
+    DROP TABLE old_person;
+
+    -- schemaflow:lint-ignore drop-table
+    CREATE INDEX person_age_idx ON person (age);
+  `
+
+  t.Run("lint ignore", func(t *testing.T) {
+    correct := []string{ LINT_INDEX_NOT_CONCURRENT }
+    checked := lintRulesFound(migration, nil)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestLintDisabled(t *testing.T) {
+  migration := `
+    ALTER TABLE person DROP COLUMN name;
+    DROP TABLE old_person;
+  `
+
+  t.Run("lint disabled", func(t *testing.T) {
+    correct := []string{ LINT_DROP_TABLE }
+    checked := lintRulesFound(migration, map[string]bool{ LINT_DROP_COLUMN: true })
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestLintNewTable(t *testing.T) {
+  migration := `
+    CREATE TABLE person (id serial PRIMARY KEY);
+    ALTER TABLE person ADD COLUMN age integer NOT NULL;
+    ALTER TABLE person ADD CONSTRAINT person_parent_fk FOREIGN KEY (parent_id) REFERENCES person (id);
+    CREATE INDEX person_age_idx ON person (age);
+  `
+
+  t.Run("lint new table", func(t *testing.T) {
+    checked := lintRulesFound(migration, nil)
+
+    if len(checked) != 0 {
+      test_failed(t, checked, []string{})
+    }
+  })
+}
+
+func TestLintLine(t *testing.T) {
+  migration := "CREATE TABLE a (id int);\n\n-- a comment\nDROP TABLE b;\n"
+
+  t.Run("lint line", func(t *testing.T) {
+    stmts, e := parseMigrationStmts("0001.sql", migration)
+    perr(e)
+
+    findings := lintMigrationStmts(stmts, nil)
+
+    if len(findings) != 1 || findings[0].line != 4 {
+      test_failed(t, findings, "one finding on line 4")
+    }
+  })
+}
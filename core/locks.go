@@ -0,0 +1,404 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Table level lock modes, numbered the same way Postgres numbers them so a
+// higher number is a stronger lock.
+type LockMode int
+
+const (
+  LOCK_NONE LockMode = iota
+  LOCK_ACCESS_SHARE
+  LOCK_ROW_SHARE
+  LOCK_ROW_EXCLUSIVE
+  LOCK_SHARE_UPDATE_EXCLUSIVE
+  LOCK_SHARE
+  LOCK_SHARE_ROW_EXCLUSIVE
+  LOCK_EXCLUSIVE
+  LOCK_ACCESS_EXCLUSIVE
+)
+
+func (m LockMode) String() string {
+  switch m {
+    case LOCK_ACCESS_SHARE:
+      return "ACCESS SHARE"
+    case LOCK_ROW_SHARE:
+      return "ROW SHARE"
+    case LOCK_ROW_EXCLUSIVE:
+      return "ROW EXCLUSIVE"
+    case LOCK_SHARE_UPDATE_EXCLUSIVE:
+      return "SHARE UPDATE EXCLUSIVE"
+    case LOCK_SHARE:
+      return "SHARE"
+    case LOCK_SHARE_ROW_EXCLUSIVE:
+      return "SHARE ROW EXCLUSIVE"
+    case LOCK_EXCLUSIVE:
+      return "EXCLUSIVE"
+    case LOCK_ACCESS_EXCLUSIVE:
+      return "ACCESS EXCLUSIVE"
+    default:
+      return "NONE"
+  }
+}
+
+type stmtLock struct {
+  relation string
+  mode LockMode
+}
+
+// Collects the strongest lock taken on each relation by a statement.
+type stmtLocks struct {
+  locks []*stmtLock
+}
+
+func (l *stmtLocks) add(relation string, mode LockMode) {
+  if relation == "" || mode == LOCK_NONE {
+    return
+  }
+
+  for _, lock := range l.locks {
+    if lock.relation == relation {
+      lock.mode = max(lock.mode, mode)
+      return
+    }
+  }
+
+  l.locks = append(l.locks, &stmtLock { relation, mode })
+}
+
+func (l *stmtLocks) addRangevar(rv *pg_query.RangeVar, mode LockMode) {
+  if rv != nil {
+    l.add(pgRangevarToString(rv), mode)
+  }
+}
+
+// Relations that are only read, found the same way dependencies are.
+func (l *stmtLocks) addReads(node *pg_query.Node) {
+  ps := &ParsedStmt{}
+  hydrateStmtObject(node, ps)
+
+  for _, dep := range ps.Dependencies {
+    if dep.StmtType == TABLE {
+      l.add(dep.StmtName, LOCK_ACCESS_SHARE)
+    }
+  }
+}
+
+func (l *stmtLocks) strongest() LockMode {
+  mode := LOCK_NONE
+
+  for _, lock := range l.locks {
+    mode = max(mode, lock.mode)
+  }
+
+  return mode
+}
+
+func pgListToQualifiedString(list *pg_query.List) string {
+  var names []string
+
+  for _, item := range list.GetItems() {
+    if str := item.GetString_(); str != nil {
+      names = append(names, str.GetSval())
+    }
+  }
+
+  return strings.Join(names, ".")
+}
+
+func alterTableCmdLockMode(cmd *pg_query.AlterTableCmd) LockMode {
+  switch cmd.GetSubtype() {
+    case pg_query.AlterTableType_AT_AddConstraint: {
+      if cmd.GetDef().GetConstraint().GetContype() == pg_query.ConstrType_CONSTR_FOREIGN {
+        return LOCK_SHARE_ROW_EXCLUSIVE
+      }
+
+      return LOCK_ACCESS_EXCLUSIVE
+    }
+
+    case pg_query.AlterTableType_AT_ValidateConstraint,
+      pg_query.AlterTableType_AT_SetStatistics,
+      pg_query.AlterTableType_AT_ClusterOn,
+      pg_query.AlterTableType_AT_DropCluster,
+      pg_query.AlterTableType_AT_SetOptions,
+      pg_query.AlterTableType_AT_ResetOptions,
+      pg_query.AlterTableType_AT_AttachPartition: {
+      return LOCK_SHARE_UPDATE_EXCLUSIVE
+    }
+
+    case pg_query.AlterTableType_AT_DetachPartition: {
+      if cmd.GetDef().GetPartitionCmd().GetConcurrent() {
+        return LOCK_SHARE_UPDATE_EXCLUSIVE
+      }
+
+      return LOCK_ACCESS_EXCLUSIVE
+    }
+
+    case pg_query.AlterTableType_AT_EnableTrig,
+      pg_query.AlterTableType_AT_EnableAlwaysTrig,
+      pg_query.AlterTableType_AT_EnableReplicaTrig,
+      pg_query.AlterTableType_AT_EnableTrigAll,
+      pg_query.AlterTableType_AT_EnableTrigUser,
+      pg_query.AlterTableType_AT_DisableTrig,
+      pg_query.AlterTableType_AT_DisableTrigAll,
+      pg_query.AlterTableType_AT_DisableTrigUser: {
+      return LOCK_SHARE_ROW_EXCLUSIVE
+    }
+
+    default: {
+      return LOCK_ACCESS_EXCLUSIVE
+    }
+  }
+}
+
+func getStmtLocks(raw *pg_query.RawStmt) *stmtLocks {
+  l := &stmtLocks{}
+
+  switch n := raw.GetStmt().GetNode().(type) {
+    case *pg_query.Node_CreateStmt: {
+      for _, parent := range n.CreateStmt.GetInhRelations() {
+        if n.CreateStmt.GetPartbound() != nil {
+          l.addRangevar(parent.GetRangeVar(), LOCK_ACCESS_EXCLUSIVE)
+        } else {
+          l.addRangevar(parent.GetRangeVar(), LOCK_SHARE_UPDATE_EXCLUSIVE)
+        }
+      }
+
+      for _, elt := range n.CreateStmt.GetTableElts() {
+        for _, c := range elt.GetColumnDef().GetConstraints() {
+          l.addRangevar(c.GetConstraint().GetPktable(), LOCK_SHARE_ROW_EXCLUSIVE)
+        }
+      }
+
+      for _, c := range n.CreateStmt.GetConstraints() {
+        l.addRangevar(c.GetConstraint().GetPktable(), LOCK_SHARE_ROW_EXCLUSIVE)
+      }
+    }
+
+    case *pg_query.Node_AlterTableStmt: {
+      relation := n.AlterTableStmt.GetRelation()
+
+      for _, cmd := range n.AlterTableStmt.GetCmds() {
+        atc := cmd.GetAlterTableCmd()
+        l.addRangevar(relation, alterTableCmdLockMode(atc))
+
+        if c := atc.GetDef().GetConstraint(); c != nil {
+          l.addRangevar(c.GetPktable(), LOCK_SHARE_ROW_EXCLUSIVE)
+        }
+
+        for _, c := range atc.GetDef().GetColumnDef().GetConstraints() {
+          l.addRangevar(c.GetConstraint().GetPktable(), LOCK_SHARE_ROW_EXCLUSIVE)
+        }
+
+        if pc := atc.GetDef().GetPartitionCmd(); pc != nil {
+          l.addRangevar(pc.GetName(), LOCK_ACCESS_EXCLUSIVE)
+        }
+      }
+    }
+
+    case *pg_query.Node_IndexStmt: {
+      if n.IndexStmt.GetConcurrent() {
+        l.addRangevar(n.IndexStmt.GetRelation(), LOCK_SHARE_UPDATE_EXCLUSIVE)
+      } else {
+        l.addRangevar(n.IndexStmt.GetRelation(), LOCK_SHARE)
+      }
+    }
+
+    case *pg_query.Node_DropStmt: {
+      mode := LOCK_ACCESS_EXCLUSIVE
+
+      if n.DropStmt.GetConcurrent() {
+        mode = LOCK_SHARE_UPDATE_EXCLUSIVE
+      }
+
+      switch n.DropStmt.GetRemoveType() {
+        case pg_query.ObjectType_OBJECT_TABLE,
+          pg_query.ObjectType_OBJECT_VIEW,
+          pg_query.ObjectType_OBJECT_MATVIEW,
+          pg_query.ObjectType_OBJECT_INDEX,
+          pg_query.ObjectType_OBJECT_SEQUENCE,
+          pg_query.ObjectType_OBJECT_FOREIGN_TABLE: {
+          for _, object := range n.DropStmt.GetObjects() {
+            l.add(pgListToQualifiedString(object.GetList()), mode)
+          }
+        }
+      }
+    }
+
+    case *pg_query.Node_RenameStmt: {
+      l.addRangevar(n.RenameStmt.GetRelation(), LOCK_ACCESS_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_TruncateStmt: {
+      for _, rel := range n.TruncateStmt.GetRelations() {
+        l.addRangevar(rel.GetRangeVar(), LOCK_ACCESS_EXCLUSIVE)
+      }
+    }
+
+    case *pg_query.Node_VacuumStmt: {
+      mode := LOCK_SHARE_UPDATE_EXCLUSIVE
+
+      for _, option := range n.VacuumStmt.GetOptions() {
+        if n.VacuumStmt.GetIsVacuumcmd() && option.GetDefElem().GetDefname() == "full" {
+          mode = LOCK_ACCESS_EXCLUSIVE
+        }
+      }
+
+      for _, rel := range n.VacuumStmt.GetRels() {
+        l.addRangevar(rel.GetVacuumRelation().GetRelation(), mode)
+      }
+    }
+
+    case *pg_query.Node_ClusterStmt: {
+      l.addRangevar(n.ClusterStmt.GetRelation(), LOCK_ACCESS_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_RefreshMatViewStmt: {
+      if n.RefreshMatViewStmt.GetConcurrent() {
+        l.addRangevar(n.RefreshMatViewStmt.GetRelation(), LOCK_EXCLUSIVE)
+      } else {
+        l.addRangevar(n.RefreshMatViewStmt.GetRelation(), LOCK_ACCESS_EXCLUSIVE)
+      }
+    }
+
+    case *pg_query.Node_LockStmt: {
+      for _, rel := range n.LockStmt.GetRelations() {
+        l.addRangevar(rel.GetRangeVar(), LockMode(n.LockStmt.GetMode()))
+      }
+    }
+
+    case *pg_query.Node_CreateTrigStmt: {
+      l.addRangevar(n.CreateTrigStmt.GetRelation(), LOCK_SHARE_ROW_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_RuleStmt: {
+      l.addRangevar(n.RuleStmt.GetRelation(), LOCK_ACCESS_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_CreatePolicyStmt: {
+      l.addRangevar(n.CreatePolicyStmt.GetTable(), LOCK_ACCESS_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_AlterPolicyStmt: {
+      l.addRangevar(n.AlterPolicyStmt.GetTable(), LOCK_ACCESS_EXCLUSIVE)
+    }
+
+    case *pg_query.Node_ViewStmt: {
+      if n.ViewStmt.GetReplace() {
+        l.addRangevar(n.ViewStmt.GetView(), LOCK_ACCESS_EXCLUSIVE)
+      }
+
+      l.addReads(n.ViewStmt.GetQuery())
+    }
+
+    case *pg_query.Node_CreateTableAsStmt: {
+      l.addReads(n.CreateTableAsStmt.GetQuery())
+    }
+
+    case *pg_query.Node_InsertStmt: {
+      l.addRangevar(n.InsertStmt.GetRelation(), LOCK_ROW_EXCLUSIVE)
+      l.addReads(n.InsertStmt.GetSelectStmt())
+    }
+
+    case *pg_query.Node_UpdateStmt: {
+      l.addRangevar(n.UpdateStmt.GetRelation(), LOCK_ROW_EXCLUSIVE)
+
+      for _, f := range n.UpdateStmt.GetFromClause() {
+        l.addReads(f)
+      }
+
+      l.addReads(n.UpdateStmt.GetWhereClause())
+    }
+
+    case *pg_query.Node_DeleteStmt: {
+      l.addRangevar(n.DeleteStmt.GetRelation(), LOCK_ROW_EXCLUSIVE)
+
+      for _, u := range n.DeleteStmt.GetUsingClause() {
+        l.addReads(u)
+      }
+
+      l.addReads(n.DeleteStmt.GetWhereClause())
+    }
+
+    case *pg_query.Node_SelectStmt: {
+      l.addReads(raw.GetStmt())
+    }
+  }
+
+  return l
+}
+
+func truncateStmtText(text string, length int) string {
+  text = strings.Join(strings.Fields(text), " ")
+
+  if len(text) <= length {
+    return text
+  }
+
+  return text[:length - 3] + "..."
+}
+
+func (l *stmtLocks) sorted() []*stmtLock {
+  locks := append([]*stmtLock{}, l.locks...)
+
+  sort.SliceStable(locks, func(i, j int) bool {
+    return locks[i].mode > locks[j].mode
+  })
+
+  return locks
+}
+
+// Databases and schemas migrated concurrently each write a report.
+var lockReportMutex sync.Mutex
+
+// One row per relation locked, the statement is only printed on its first
+// row. The report is written to w in one go so reports don't interleave.
+func writeLockReport(w io.Writer, stmts []*migrationStmt) error {
+  var report bytes.Buffer
+  tw := tabwriter.NewWriter(&report, 0, 0, 2, ' ', 0)
+
+  fmt.Fprintln(tw, "FILE\tLINE\tLOCK\tRELATION\tSTATEMENT")
+
+  for _, stmt := range stmts {
+    deparsed, err := deparseRawStmt(stmt.raw)
+
+    if err != nil {
+      return err
+    }
+
+    text := truncateStmtText(deparsed, 60)
+    locks := getStmtLocks(stmt.raw).sorted()
+
+    if len(locks) == 0 {
+      locks = append(locks, &stmtLock { "-", LOCK_NONE })
+    }
+
+    for i, lock := range locks {
+      if i > 0 {
+        text = ""
+      }
+
+      fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", extractFileFromPath(stmt.file), stmt.line, lock.mode, lock.relation, text)
+    }
+  }
+
+  if err := tw.Flush(); err != nil {
+    return err
+  }
+
+  lockReportMutex.Lock()
+  defer lockReportMutex.Unlock()
+
+  _, err := w.Write(report.Bytes())
+  return err
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func locksTaken(code string) [][]string {
+  stmts, e := parseMigrationStmts("0001.sql", code)
+  perr(e)
+
+  var taken [][]string
+
+  for _, stmt := range stmts {
+    var locks []string
+
+    for _, lock := range getStmtLocks(stmt.raw).sorted() {
+      locks = append(locks, lock.mode.String() + " " + lock.relation)
+    }
+
+    taken = append(taken, locks)
+  }
+
+  return taken
+}
+
+func TestAlterTableLocks(t *testing.T) {
+  migration := `
+    ALTER TABLE person ADD COLUMN age integer;
+    ALTER TABLE person ADD CONSTRAINT person_parent_fk FOREIGN KEY (parent_id) REFERENCES parent (id);
+    ALTER TABLE person VALIDATE CONSTRAINT person_parent_fk;
+    ALTER TABLE person ENABLE TRIGGER ALL, ADD COLUMN name text;
+  `
+
+  t.Run("alter table locks", func(t *testing.T) {
+    correct := [][]string{
+      { "ACCESS EXCLUSIVE person" },
+      { "SHARE ROW EXCLUSIVE person", "SHARE ROW EXCLUSIVE parent" },
+      { "SHARE UPDATE EXCLUSIVE person" },
+      { "ACCESS EXCLUSIVE person" },
+    }
+
+    checked := locksTaken(migration)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestIndexLocks(t *testing.T) {
+  migration := `
+    CREATE INDEX person_age_idx ON person (age);
+    CREATE INDEX CONCURRENTLY person_name_idx ON public.person (name);
+    DROP INDEX person_age_idx;
+  `
+
+  t.Run("index locks", func(t *testing.T) {
+    correct := [][]string{
+      { "SHARE person" },
+      { "SHARE UPDATE EXCLUSIVE public.person" },
+      { "ACCESS EXCLUSIVE person_age_idx" },
+    }
+
+    checked := locksTaken(migration)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestDataLocks(t *testing.T) {
+  migration := `
+    INSERT INTO person (name) SELECT name FROM old_person;
+    LOCK TABLE person IN SHARE MODE;
+    CREATE TABLE child (parent_id int REFERENCES person (id));
+    SET search_path TO public;
+  `
+
+  t.Run("data locks", func(t *testing.T) {
+    correct := [][]string{
+      { "ROW EXCLUSIVE person", "ACCESS SHARE old_person" },
+      { "SHARE person" },
+      { "SHARE ROW EXCLUSIVE person" },
+      nil,
+    }
+
+    checked := locksTaken(migration)
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+// Records each write, so a report written in pieces shows up as several.
+type writesRecorder struct {
+  writes []string
+}
+
+func (w *writesRecorder) Write(p []byte) (int, error) {
+  w.writes = append(w.writes, string(p))
+  return len(p), nil
+}
+
+func TestLockReportsDontInterleave(t *testing.T) {
+  stmts, e := parseMigrationStmts("0001.sql", `
+    ALTER TABLE person ADD COLUMN age integer;
+    CREATE INDEX person_age_idx ON person (age);
+  `)
+  perr(e)
+
+  t.Run("lock reports don't interleave", func(t *testing.T) {
+    recorder := &writesRecorder{}
+    var wg sync.WaitGroup
+
+    for i := 0; i < 8; i++ {
+      wg.Add(1)
+
+      go func() {
+        defer wg.Done()
+        perr(writeLockReport(recorder, stmts))
+      }()
+    }
+
+    wg.Wait()
+
+    if len(recorder.writes) != 8 {
+      test_failed(t, len(recorder.writes), 8)
+    }
+
+    for _, report := range recorder.writes {
+      if strings.Count(report, "FILE") != 1 || !strings.Contains(report, "person_age_idx") {
+        test_failed(t, report, "one whole report")
+      }
+    }
+  })
+}
@@ -0,0 +1,65 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Migrations are read through an fs.FS so they can be embedded with
+// //go:embed. Without one the files in MigrationPath are used.
+func getMigrationFS(ctx *Context) fs.FS {
+  if ctx.MigrationFS != nil {
+    return ctx.MigrationFS
+  }
+
+  return os.DirFS(ctx.MigrationPath)
+}
+
+func listMigrationFiles(ctx *Context) ([]string, error) {
+  var files []string
+
+  err := fs.WalkDir(getMigrationFS(ctx), ".", func(path string, d fs.DirEntry, err error) error {
+    if err != nil {
+      // A migrations folder that doesn't exist yet has no migrations in it.
+      if path == "." && errors.Is(err, fs.ErrNotExist) {
+        return fs.SkipDir
+      }
+
+      return err
+    }
+
+    if !d.IsDir() && strings.HasSuffix(path, ".sql") {
+      files = append(files, path)
+    }
+
+    return nil
+  })
+
+  return files, err
+}
+
+func readMigrationFile(ctx *Context, file string) (string, error) {
+  data, err := fs.ReadFile(getMigrationFS(ctx), file)
+  return string(data), err
+}
+
+func hashMigrationFile(ctx *Context, file string) (string, error) {
+  code, err := readMigrationFile(ctx, file)
+
+  if err != nil {
+    return "", err
+  }
+
+  return HashString(code), nil
+}
+
+// Make and squash write files, which can only be done in MigrationPath.
+func checkMigrationsWritable(ctx *Context) error {
+  if ctx.MigrationFS != nil {
+    return errors.New("migrations read from an fs.FS can't be written to, use MigrationPath instead")
+  }
+
+  return nil
+}
@@ -2,27 +2,55 @@ package core
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sergi/go-diff/diffmatchpatch"
 )
 
-func getMigrationFilesSorted(ctx *Context) []string {
-  files := ListAllFilesInPath(ctx.MigrationPath)
+func getMigrationFilesSorted(ctx *Context) ([]string, error) {
+  files, err := listMigrationFiles(ctx)
   sort.Strings(files)
-  return files
+  return files, err
 }
 
-func getListOfUnexecutedMigrations(ctx *Context) []string {
+// Migration files and registered Go migrations, ordered by name.
+func getMigrationsSorted(ctx *Context) ([]string, error) {
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  migrations := append(files, getGoMigrationsSorted(ctx)...)
+
+  sort.SliceStable(migrations, func(i, j int) bool {
+    return extractFileFromPath(migrations[i]) < extractFileFromPath(migrations[j])
+  })
+
+  return migrations, nil
+}
+
+func getListOfUnexecutedMigrations(ctx *Context) ([]string, error) {
   var unexecutedMigrations []string
 
-  executedFiles := getListOfExecutedMigrationFiles(ctx)
+  executedFiles, err := getListOfExecutedMigrationFiles(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  migrations, err := getMigrationsSorted(ctx)
+
+  if err != nil {
+    return nil, err
+  }
 
-  for _, mf := range getMigrationFilesSorted(ctx) {
+  for _, mf := range migrations {
     shouldBeExecuted := true
     for _, ef := range executedFiles {
       if extractFileFromPath(mf) == extractFileFromPath(ef.fileName) {
@@ -36,62 +64,149 @@ func getListOfUnexecutedMigrations(ctx *Context) []string {
     }
   }
 
-  return unexecutedMigrations
+  return unexecutedMigrations, nil
 }
 
-func getNextMigrationFileName(ctx *Context) string {
-  all_migrations := getMigrationFilesSorted(ctx)
-  migration_number := len(all_migrations)
-  return fmt.Sprintf("%04d.sql", migration_number)
+func getNextMigrationFileName(ctx *Context) (string, error) {
+  migration_number := 0
+
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return "", err
+  }
+
+  // Squashing leaves gaps in the numbering so the count of files can't be used.
+  for _, file := range files {
+    if number, ok := migrationNumber(file); ok && number >= migration_number {
+      migration_number = number + 1
+    }
+  }
+
+  return fmt.Sprintf("%04d.sql", migration_number), nil
 }
 
-func isValidationStringInFile(ctx *Context, file string) bool {
-  for _, line := range strings.Split(readFileToString(ctx, file), "\n") {
+func isValidationStringInFile(ctx *Context, file string) (bool, error) {
+  code, err := readMigrationFile(ctx, file)
+
+  if err != nil {
+    return false, err
+  }
+
+  for _, line := range strings.Split(code, "\n") {
     if line == VALIDATE_MIGRATIONS_STRING {
-      return true
+      return true, nil
     }
   }
 
-  return false
+  return false, nil
 }
 
-func getMigrationFilesWithUnresolvedMigrations(ctx *Context) []string {
+func getMigrationFilesWithUnresolvedMigrations(ctx *Context) ([]string, error) {
   var migration_files []string
 
-  for _, file := range getMigrationFilesSorted(ctx) {
-    if isValidationStringInFile(ctx, file) {
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, file := range files {
+    unresolved, err := isValidationStringInFile(ctx, file)
+
+    if err != nil {
+      return nil, err
+    }
+
+    if unresolved {
       migration_files = append(migration_files, file)
     }
   }
 
-  return migration_files
+  return migration_files, nil
 }
 
-func getTamperedMigrations(ctx *Context) []string {
+func getTamperedMigrations(ctx *Context) ([]string, error) {
   var tampered []string
-  
-  for _, em := range getListOfExecutedMigrationFiles(ctx) {
-    path := filepath.Join(ctx.MigrationPath, em.fileName)
 
-    if HashFile(path) != em.fileHash {
+  squashed, err := getSquashedMigrations(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  executed, err := getListOfExecutedMigrationFiles(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  found := make(map[string]string)
+
+  for _, file := range files {
+    found[extractFileFromPath(file)] = file
+  }
+
+  for _, em := range executed {
+    path, exists := found[em.fileName]
+
+    if _, ok := squashed[em.fileName]; ok && !exists {
+      continue
+    }
+
+    if isGoMigrationName(em.fileName) {
+      continue
+    }
+
+    if !exists {
+      tampered = append(tampered, em.fileName)
+      continue
+    }
+
+    hash, err := hashMigrationFile(ctx, path)
+
+    if err != nil {
+      return nil, err
+    }
+
+    if hash != em.fileHash {
       tampered = append(tampered, path)
     }
   }
 
-  return tampered
+  return tampered, nil
 }
 
-func checkExecutedMigrationsUnchanged(ctx *Context) {
-  tampered := getTamperedMigrations(ctx)
+func checkExecutedMigrationsUnchanged(ctx *Context) error {
+  tampered, err := getTamperedMigrations(ctx)
+
+  if err != nil {
+    return err
+  }
+
   if len(tampered) > 0 {
-    log.Fatalf("The following executed migrations have been tampered with: %s\nCannot continue.\n", strings.Join(tampered, ", "))
+    return &TamperedMigrationsError { tampered }
   }
+
+  return nil
 }
 
-func getRemovedStatementsAndUpdateDb(ctx *Context) []string {
-  var removed []string
+func getRemovedStatements(ctx *Context) ([]statements, error) {
+  var removed []statements
+
+  stmts, err := getListOfStatementsInDb(ctx)
 
-  for _, f := range getListOfStatementsInDb(ctx) {
+  if err != nil {
+    return nil, err
+  }
+
+  for _, f := range stmts {
     nameFound := false
     hashFound := false
     for _, s := range *ctx.Stmts {
@@ -105,18 +220,35 @@ func getRemovedStatementsAndUpdateDb(ctx *Context) []string {
     }
 
     if !hashFound && !nameFound {
-      removed = append(removed, *f.stmt)
-      removeStmtByHash(ctx, *f.stmtHash)
+      removed = append(removed, f)
     }
   }
 
-  return removed
+  return removed, nil
 }
 
-func generateDiffComment(ctx *Context, stmt *ParsedStmt) string {
-  prevDeparsed, e := deparseRawStmt(stmt.PrevStmt)
-  perr(e)
+func getRemovedStatementsAndUpdateDb(ctx *Context) ([]string, error) {
+  var removed []string
+
+  stmts, err := getRemovedStatements(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, f := range stmts {
+    removed = append(removed, *f.stmt)
+
+    if err := removeStmtByHash(ctx, *f.stmtHash); err != nil {
+      return nil, err
+    }
+  }
+
+  return removed, nil
+}
 
+func generateDiffComment(ctx *Context, stmt *ParsedStmt) (string, error) {
+  prevDeparsed := stmt.PrevDeparsed
   diffText := ""
 
   dmp := diffmatchpatch.New()
@@ -146,7 +278,7 @@ func generateDiffComment(ctx *Context, stmt *ParsedStmt) string {
 %s
 ----------   CHANGE DIFF   ----------
 %s
-*/`, VALIDATE_MIGRATIONS_STRING, prevDeparsed, stmt.Deparsed, diffText)
+*/`, VALIDATE_MIGRATIONS_STRING, prevDeparsed, stmt.Deparsed, diffText), nil
 
 }
 
@@ -158,110 +290,336 @@ func generateRemovedComment(ctx *Context, remove string) string {
 */`, VALIDATE_MIGRATIONS_STRING, remove);
 }
 
-func writeMigrationsToNextMigration(ctx *Context) int {
-  nextMigrationFile := filepath.Join(ctx.MigrationPath, getNextMigrationFileName(ctx))
-
+func writeMigrationsToNextMigration(ctx *Context, nextMigrationFile string) (int, error) {
   var migrations []string
 
   for _, stmt := range *ctx.Stmts {
     switch stmt.Status {
       case NEW: {
-        migrations = append(migrations, stmt.Deparsed) 
-        addStmtToDb(ctx, stmt)
+        migrations = append(migrations, stmt.Deparsed)
+
+        if err := addStmtToDb(ctx, stmt); err != nil {
+          return 0, err
+        }
       }
 
       case CHANGED: {
-        migrations = append(migrations, generateDiffComment(ctx, stmt))
-        updateStmtInDb(ctx, stmt)
+        comment, err := generateDiffComment(ctx, stmt)
+
+        if err != nil {
+          return 0, err
+        }
+
+        migrations = append(migrations, comment)
+
+        if err := updateStmtInDb(ctx, stmt); err != nil {
+          return 0, err
+        }
       }
     }
   }
 
-  for _, removed := range getRemovedStatementsAndUpdateDb(ctx) {
-    migrations = append(migrations, generateRemovedComment(ctx, removed))
+  removed, err := getRemovedStatementsAndUpdateDb(ctx)
+
+  if err != nil {
+    return 0, err
   }
 
-  f, err := os.Create(nextMigrationFile)
-  perr(err)
+  for _, r := range removed {
+    migrations = append(migrations, generateRemovedComment(ctx, r))
+  }
+
+  statements := len(migrations)
 
-  defer f.Close()
+  // Later runs of migrate use the role make was checked against.
+  if ctx.Role != "" {
+    migrations = append([]string{ DIRECTIVE_PREFIX + DIRECTIVE_ROLE + " " + ctx.Role }, migrations...)
+  }
 
-  f.WriteString(string(strings.Join(migrations, "\n")))
+  err = os.WriteFile(nextMigrationFile, []byte(strings.Join(migrations, "\n")), 0644)
 
-  return len(migrations)
+  return statements, err
 }
 
-func executeMigration(ctx *Context, migrationFile string)  {
-  filename := extractFileFromPath(migrationFile)
-  code := readFileToString(ctx, migrationFile)
+func executeMigration(ctx *Context, migrationFile string) error {
+  if isGoMigration(ctx, migrationFile) {
+    return executeGoMigration(ctx, migrationFile)
+  }
+
+  code, err := readMigrationFile(ctx, migrationFile)
 
-  _, err := ctx.DbTx.Exec(code)
-  perr(err)
+  if err != nil {
+    return err
+  }
+
+  role := getMigrationRole(ctx, code)
+
+  if err := setRole(ctx, role); err != nil {
+    return &MigrationError { migrationFile, err }
+  }
+
+  if _, err := ctx.DbTx.ExecContext(getCtx(ctx), code); err != nil {
+    return &MigrationError { migrationFile, err }
+  }
 
-  _, err = ctx.DbTx.Exec("insert into schemaflow.migrations (file_name, file_hash) values ($1, $2)", filename, HashFile(migrationFile))
-  perr(err)
+  if err := resetRole(ctx, role); err != nil {
+    return err
+  }
+
+  return recordMigrationAsExecuted(ctx, migrationFile)
 }
 
-func checkForUnresolvedMigrations(ctx *Context) {
-  unresolved_migration_files := getMigrationFilesWithUnresolvedMigrations(ctx)
+func checkForUnresolvedMigrations(ctx *Context) error {
+  unresolved_migration_files, err := getMigrationFilesWithUnresolvedMigrations(ctx)
+
+  if err != nil {
+    return err
+  }
 
   if len(unresolved_migration_files) > 0 {
-    log.Fatalf("The following files have unresolved migrations: %s\n", strings.Join(unresolved_migration_files, ", "))
+    return &UnresolvedMigrationsError { unresolved_migration_files }
   }
+
+  return nil
 }
 
-func areMigrationsRequired(ctx *Context) bool {
+func areMigrationsRequired(ctx *Context) (bool, error) {
   for _, stmt := range *ctx.Stmts {
     if stmt.Status == UNKNOWN {
-      log.Fatalf("Status of statement %v is UNKNOWN. This is a bug.", stmt)
+      return false, fmt.Errorf("status of statement %v is UNKNOWN. This is a bug", stmt.Deparsed)
     } else if stmt.Status != UNCHANGED {
-      return true
+      return true, nil
     }
   }
 
-  return false
+  return false, nil
 }
 
-func runMigrations(ctx *Context) {
-  migrations := getListOfUnexecutedMigrations(ctx)
+func runMigrations(ctx *Context, result *MigrateResult) error {
+  var migrations []string
+
+  unexecuted, err := getListOfUnexecutedMigrations(ctx)
+
+  if err != nil {
+    return err
+  }
+
+  for _, migration := range unexecuted {
+    applied, err := isBaselineAlreadyApplied(ctx, migration)
+
+    if err != nil {
+      return err
+    }
+
+    if applied {
+      getLogger(ctx).Info("Recording baseline as executed, every migration it replaces has already run", "file", migration)
+
+      if err := recordMigrationAsExecuted(ctx, migration); err != nil {
+        return err
+      }
+
+      emit(ctx, Event { Type: EVENT_MIGRATION_RECORDED, File: migration })
+      result.Recorded = append(result.Recorded, migration)
+      continue
+    }
+
+    migrations = append(migrations, migration)
+  }
+
+  if err := checkForDestructiveMigrations(ctx, migrations); err != nil {
+    return err
+  }
 
   for _, migration := range migrations {
-    log.Printf("Executing %s\n", migration)
-    executeMigration(ctx, migration)
+    getLogger(ctx).Info("Executing migration", "file", migration)
+    emit(ctx, Event { Type: EVENT_MIGRATION_STARTED, File: migration })
+
+    started := time.Now()
+    err := executeMigration(ctx, migration)
+    emit(ctx, Event { Type: EVENT_MIGRATION_FINISHED, File: migration, Duration: time.Since(started), Err: err })
+
+    if err != nil {
+      return err
+    }
+
+    getLogger(ctx).Info("Executed migration", "file", migration, "duration", time.Since(started))
+
+    result.Executed = append(result.Executed, migration)
   }
+
+  return nil
 }
 
-func setup(ctx *Context) {
-  checkForUnresolvedMigrations(ctx)
-  checkExecutedMigrationsUnchanged(ctx)
+// Prints what migrate would execute and the locks it would take, without executing anything.
+func reportPendingMigrations(ctx *Context, migrations []string) error {
+  var all []*migrationStmt
+
+  for _, migration := range migrations {
+    getLogger(ctx).Info("Would execute migration", "file", migration)
+
+    // The lock report only understands postgres statements.
+    if isGoMigration(ctx, migration) || getDialect(ctx).Name() != DIALECT_POSTGRES {
+      continue
+    }
+
+    code, err := readMigrationFile(ctx, migration)
+
+    if err != nil {
+      return err
+    }
+
+    stmts, err := parseMigrationStmts(migration, code)
+
+    if err != nil {
+      return &SyntaxError { migration, err }
+    }
+
+    all = append(all, stmts...)
+  }
+
+  if getDialect(ctx).Name() != DIALECT_POSTGRES {
+    return nil
+  }
+
+  return writeLockReport(os.Stdout, all)
 }
 
-func MakeMigrations(ctx *Context) {
-  setup(ctx)
+func setup(ctx *Context) error {
+  if err := checkForUnresolvedMigrations(ctx); err != nil {
+    return err
+  }
+
+  return checkExecutedMigrationsUnchanged(ctx)
+}
 
-  ctx.Stmts = buildParsedStmts(ctx)
-  
-  if !areMigrationsRequired(ctx) {
-    log.Println("No migrations required.")
-    return
+func MakeMigrations(ctx *Context) (*MakeResult, error) {
+  result := new(MakeResult)
+
+  if err := checkMigrationsWritable(ctx); err != nil {
+    return nil, err
   }
 
-  next_migration := getNextMigrationFileName(ctx)
+  if err := setup(ctx); err != nil {
+    return nil, err
+  }
 
-  numOfMigrationsRun := writeMigrationsToNextMigration(ctx)
+  // checkOwners reads the schema unqualified names are created in.
+  if err := setSearchPath(ctx); err != nil {
+    return nil, err
+  }
 
-  log.Printf("%d migrations have been written to %s\n", numOfMigrationsRun, next_migration)
+  stmts, err := buildParsedStmts(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  ctx.Stmts = stmts
+
+  required, err := areMigrationsRequired(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  if !required {
+    getLogger(ctx).Info("No migrations required")
+    return result, nil
+  }
+
+  if ctx.CheckOwner {
+    if err := checkOwners(ctx); err != nil {
+      return nil, err
+    }
+  }
+
+  next_migration, err := getNextMigrationFileName(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  result.File = filepath.Join(ctx.MigrationPath, next_migration)
+  result.Statements, err = writeMigrationsToNextMigration(ctx, result.File)
+
+  if err != nil {
+    return nil, err
+  }
+
+  getLogger(ctx).Info("Migrations written", "file", next_migration, "statements", result.Statements)
+  emit(ctx, Event { Type: EVENT_MIGRATION_WRITTEN, File: result.File, Detail: strconv.Itoa(result.Statements) })
+
+  return result, nil
+}
+
+func Migrate(ctx *Context) (*MigrateResult, error) {
+  result := new(MigrateResult)
+
+  if err := setup(ctx); err != nil {
+    return nil, err
+  }
+
+  if err := setSearchPath(ctx); err != nil {
+    return nil, err
+  }
+
+  migrations, err := getListOfUnexecutedMigrations(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  if err := checkGoMigrationsAfterBaselines(ctx, migrations); err != nil {
+    return nil, err
+  }
+
+  if len(migrations) == 0 {
+    getLogger(ctx).Info("All migrations have already been executed")
+  } else if ctx.DryRun {
+    result.Pending = migrations
+    return result, reportPendingMigrations(ctx, migrations)
+  } else if err := runMigrations(ctx, result); err != nil {
+    return nil, err
+  }
+
+  if !ctx.DryRun {
+    if err := writeSnapshot(ctx); err != nil {
+      return nil, err
+    }
+  }
+
+  return result, nil
 }
 
-func Migrate(ctx *Context) {
-  setup(ctx)
+// Status reports the state of every migration without changing anything.
+func Status(ctx *Context) (*StatusResult, error) {
+  var err error
+
+  result := new(StatusResult)
+
+  executed, err := getListOfExecutedMigrationFiles(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, em := range executed {
+    result.Executed = append(result.Executed, em.fileName)
+  }
+
+  if result.Pending, err = getListOfUnexecutedMigrations(ctx); err != nil {
+    return nil, err
+  }
+
+  if result.Unresolved, err = getMigrationFilesWithUnresolvedMigrations(ctx); err != nil {
+    return nil, err
+  }
 
-  if len(getListOfUnexecutedMigrations(ctx)) == 0 {
-    log.Println("All migrations have already been executed.")
-    return
+  if result.Tampered, err = getTamperedMigrations(ctx); err != nil {
+    return nil, err
   }
 
-  runMigrations(ctx)
+  return result, nil
 }
 
 func Clean(ctx *Context) {
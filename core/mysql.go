@@ -0,0 +1,515 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/pingcap/tidb/pkg/parser"
+	"github.com/pingcap/tidb/pkg/parser/ast"
+	"github.com/pingcap/tidb/pkg/parser/format"
+	_ "github.com/pingcap/tidb/pkg/parser/test_driver"
+)
+
+// MySQL has no schemas inside a database, so the bookkeeping tables are
+// prefixed with the bookkeeping schema instead. DDL is committed implicitly by MySQL, so a failed
+// migration can leave the statements before it applied.
+const MYSQL_MIGRATION_SCHEMA = `
+create table if not exists {migrations} (
+  file_name varchar(255) primary key not null,
+  file_hash varchar(64) not null,
+  created timestamp default current_timestamp
+);
+
+create table if not exists {statements} (
+  id integer auto_increment primary key,
+  stmt text not null,
+  stmt_hash varchar(64) unique not null,
+  stmt_type integer not null,
+  stmt_name varchar(255) default null,
+  created timestamp default current_timestamp,
+  updated timestamp default current_timestamp
+);
+`
+
+type mysqlDialect struct {}
+
+func (mysqlDialect) Name() string {
+  return DIALECT_MYSQL
+}
+
+func (mysqlDialect) Open(ctx context.Context, db_ctx *DbContext) (*sql.DB, error) {
+  if db_ctx.PgDbName == "" {
+    return nil, fmt.Errorf("'db' is required for %s", DIALECT_MYSQL)
+  }
+
+  host, port := db_ctx.PgHost, db_ctx.PgPort
+
+  if host == "" {
+    host = "127.0.0.1"
+  }
+
+  if port == 0 {
+    port = 3306
+  }
+
+  config := mysql.NewConfig()
+  config.Net = "tcp"
+  config.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+  config.User = db_ctx.PgUser
+  config.Passwd = db_ctx.PgPassword
+  config.DBName = db_ctx.PgDbName
+  // Migration files hold more than one statement.
+  config.MultiStatements = true
+
+  if err := setMysqlTls(config, db_ctx, host); err != nil {
+    return nil, err
+  }
+
+  return openDb(ctx, "mysql", config.FormatDSN())
+}
+
+// Registered with the driver when certificates are given.
+const MYSQL_TLS_CONFIG = "schemaflow"
+
+// Maps the postgres style ssl options onto the driver's TLS settings.
+func setMysqlTls(config *mysql.Config, db_ctx *DbContext, host string) error {
+  mode := db_ctx.PgSSLMode
+
+  if mode == "" && db_ctx.PgSSL {
+    mode = "require"
+  }
+
+  if db_ctx.PgSSLPassword != "" {
+    return fmt.Errorf("sslpassword is not supported for %s", DIALECT_MYSQL)
+  }
+
+  switch mode {
+    case "", "disable": {
+      return nil
+    }
+
+    case "allow", "prefer": {
+      config.TLSConfig = "preferred"
+      return nil
+    }
+
+    case "require", "verify-ca", "verify-full": {}
+
+    default: {
+      return fmt.Errorf("unknown sslmode '%s'. Available modes: %s", mode, strings.Join(SSL_MODES, ", "))
+    }
+  }
+
+  tls_config := &tls.Config{
+    ServerName: host,
+    // require only encrypts, and verify-ca checks the chain below without the host name.
+    InsecureSkipVerify: mode != "verify-full",
+  }
+
+  if db_ctx.PgSSLRootCert != "" {
+    pem, err := os.ReadFile(db_ctx.PgSSLRootCert)
+
+    if err != nil {
+      return fmt.Errorf("sslrootcert: %w", err)
+    }
+
+    tls_config.RootCAs = x509.NewCertPool()
+
+    if !tls_config.RootCAs.AppendCertsFromPEM(pem) {
+      return fmt.Errorf("sslrootcert: no certificates found in %s", db_ctx.PgSSLRootCert)
+    }
+  }
+
+  if db_ctx.PgSSLCert != "" || db_ctx.PgSSLKey != "" {
+    cert, err := tls.LoadX509KeyPair(db_ctx.PgSSLCert, db_ctx.PgSSLKey)
+
+    if err != nil {
+      return fmt.Errorf("sslcert: %w", err)
+    }
+
+    tls_config.Certificates = []tls.Certificate{ cert }
+  }
+
+  if mode == "verify-ca" {
+    tls_config.VerifyConnection = func(state tls.ConnectionState) error {
+      options := x509.VerifyOptions{ Roots: tls_config.RootCAs, Intermediates: x509.NewCertPool() }
+
+      for _, cert := range state.PeerCertificates[1:] {
+        options.Intermediates.AddCert(cert)
+      }
+
+      _, err := state.PeerCertificates[0].Verify(options)
+      return err
+    }
+  }
+
+  if err := mysql.RegisterTLSConfig(MYSQL_TLS_CONFIG, tls_config); err != nil {
+    return err
+  }
+
+  config.TLSConfig = MYSQL_TLS_CONFIG
+  return nil
+}
+
+// Whether the bookkeeping tables exist, checked without creating them.
+func isMysqlBookkeepingCreated(ctx *Context) (bool, error) {
+  var count int
+
+  query := "select count(*) from information_schema.tables where table_schema = database() and table_name in ($1, $2)"
+
+  if err := ctx.DbTx.QueryRowContext(getCtx(ctx), bookkeepingSql(ctx, query), bookkeepingSql(ctx, "{migrations}"), bookkeepingSql(ctx, "{statements}")).Scan(&count); err != nil {
+    return false, err
+  }
+
+  return count == 2, nil
+}
+
+func (mysqlDialect) MigrationSchema() string {
+  return MYSQL_MIGRATION_SCHEMA
+}
+
+func (mysqlDialect) Table(schema string, name string) string {
+  return schema + "_" + name
+}
+
+func quoteMysqlIdentifier(name string) string {
+  return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// The next value of an AUTO_INCREMENT column changes with the data.
+var mysqlAutoIncrement = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// Runs a SHOW CREATE statement and returns its column named column, or ""
+// when it's NULL, as it is for routines the user can't see the body of.
+func showMysqlCreate(ctx *Context, query string, column string) (string, error) {
+  rows, err := ctx.DbTx.QueryContext(getCtx(ctx), query)
+
+  if err != nil {
+    return "", err
+  }
+
+  defer rows.Close()
+
+  columns, err := rows.Columns()
+
+  if err != nil {
+    return "", err
+  }
+
+  values := make([]sql.NullString, len(columns))
+  targets := make([]any, len(columns))
+
+  for i := range values {
+    targets[i] = &values[i]
+  }
+
+  if !rows.Next() {
+    return "", rows.Err()
+  }
+
+  if err := rows.Scan(targets...); err != nil {
+    return "", err
+  }
+
+  for i, name := range columns {
+    if strings.EqualFold(name, column) {
+      return values[i].String, nil
+    }
+  }
+
+  return "", fmt.Errorf("%s returned no %s column", query, column)
+}
+
+func (mysqlDialect) CatalogStmts(ctx *Context) ([]string, error) {
+  var stmts []string
+
+  bookkeeping := []any{ bookkeepingSql(ctx, "{migrations}"), bookkeepingSql(ctx, "{statements}") }
+
+  // Lists the objects of one kind and how to show the DDL of each.
+  objects := []struct {
+    query string
+    args []any
+    show string
+    column string
+  }{
+    // Other projects' bookkeeping tables are told apart by their columns.
+    {
+      bookkeepingSql(ctx, `select table_name from information_schema.tables where table_schema = database() and table_type = 'BASE TABLE' and table_name not in ($1, $2)
+        and table_name not in (
+          select table_name from information_schema.columns where table_schema = database()
+          group by table_name having group_concat(column_name order by column_name separator ',') in (` + bookkeepingColumnsSql() + `)
+        )`),
+      bookkeeping, "show create table %s", "Create Table",
+    },
+    {
+      "select table_name from information_schema.views where table_schema = database()",
+      nil, "show create view %s", "Create View",
+    },
+    {
+      "select routine_name from information_schema.routines where routine_schema = database() and routine_type = 'FUNCTION'",
+      nil, "show create function %s", "Create Function",
+    },
+    {
+      "select routine_name from information_schema.routines where routine_schema = database() and routine_type = 'PROCEDURE'",
+      nil, "show create procedure %s", "Create Procedure",
+    },
+    {
+      "select trigger_name from information_schema.triggers where trigger_schema = database()",
+      nil, "show create trigger %s", "SQL Original Statement",
+    },
+  }
+
+  for _, object := range objects {
+    names, err := queryStrings(ctx, object.query, object.args...)
+
+    if err != nil {
+      return nil, fmt.Errorf("reading the catalog: %w", err)
+    }
+
+    for _, name := range names {
+      stmt, err := showMysqlCreate(ctx, fmt.Sprintf(object.show, quoteMysqlIdentifier(name)), object.column)
+
+      if err != nil {
+        return nil, fmt.Errorf("reading the catalog: %w", err)
+      }
+
+      if stmt != "" {
+        stmts = append(stmts, mysqlAutoIncrement.ReplaceAllString(stmt, "") + ";")
+      }
+    }
+  }
+
+  return stmts, nil
+}
+
+var postgresPlaceholders = regexp.MustCompile(`\$\d+`)
+
+// schemaflow's queries use every placeholder once and in order, so they can
+// all become ?.
+func (mysqlDialect) Rebind(query string) string {
+  return postgresPlaceholders.ReplaceAllString(query, "?")
+}
+
+func parseMysql(code string) ([]ast.StmtNode, error) {
+  nodes, _, err := parser.New().Parse(code, "", "")
+  return nodes, err
+}
+
+func deparseMysqlStmt(node ast.StmtNode) (string, error) {
+  var sb strings.Builder
+
+  if err := node.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &sb)); err != nil {
+    return "", err
+  }
+
+  return sb.String() + ";", nil
+}
+
+func mysqlTableName(t *ast.TableName) string {
+  if t == nil {
+    return ""
+  }
+
+  if t.Schema.L != "" {
+    return t.Schema.L + "." + t.Name.L
+  }
+
+  return t.Name.L
+}
+
+// Collects every table a statement refers to.
+type mysqlTableCollector struct {
+  tables []string
+}
+
+func (c *mysqlTableCollector) Enter(n ast.Node) (ast.Node, bool) {
+  if t, ok := n.(*ast.TableName); ok {
+    c.tables = append(c.tables, mysqlTableName(t))
+  }
+
+  return n, false
+}
+
+func (c *mysqlTableCollector) Leave(n ast.Node) (ast.Node, bool) {
+  return n, true
+}
+
+// Sets the name and type of ps from node, and depends on every table it
+// refers to other than itself.
+func hydrateMysqlStmt(node ast.StmtNode, ps *ParsedStmt) {
+  switch n := node.(type) {
+    case *ast.CreateDatabaseStmt: {
+      ps.StmtType = DATABASE
+      ps.Name = n.Name.L
+    }
+
+    case *ast.CreateTableStmt: {
+      ps.StmtType = TABLE
+      ps.Name = mysqlTableName(n.Table)
+    }
+
+    case *ast.CreateViewStmt: {
+      ps.StmtType = VIEW
+      ps.Name = mysqlTableName(n.ViewName)
+    }
+
+    // Index names are only unique within their table.
+    case *ast.CreateIndexStmt: {
+      ps.StmtType = INDEX
+      ps.Name = mysqlTableName(n.Table) + "." + n.IndexName
+    }
+
+    case *ast.CreateSequenceStmt: {
+      ps.StmtType = SEQUENCE
+      ps.Name = mysqlTableName(n.Name)
+    }
+
+    case *ast.ProcedureInfo: {
+      ps.StmtType = PROCEDURE
+      ps.Name = mysqlTableName(n.ProcedureName)
+    }
+
+    case *ast.AlterTableStmt: {
+      ps.StmtType = ALTER_TABLE
+    }
+
+    case *ast.CreateUserStmt: {
+      ps.StmtType = USER
+    }
+
+    case *ast.GrantStmt, *ast.GrantRoleStmt: {
+      ps.StmtType = GRANT
+    }
+
+    case *ast.InsertStmt: {
+      ps.StmtType = INSERT
+    }
+
+    case *ast.UpdateStmt: {
+      ps.StmtType = UPDATE
+    }
+
+    case *ast.SelectStmt: {
+      ps.StmtType = SELECT
+    }
+
+    // Depends on the index it drops, which is named with its table.
+    case *ast.DropIndexStmt: {
+      ps.StmtType = DROP
+      appendDependency(ps, INDEX, mysqlTableName(n.Table) + "." + n.IndexName)
+    }
+
+    case *ast.DropTableStmt, *ast.DropDatabaseStmt, *ast.DropSequenceStmt, *ast.DropProcedureStmt: {
+      ps.StmtType = DROP
+    }
+
+    default: {
+      ps.StmtType = UNKNOWN_TYPE
+    }
+  }
+
+  collector := &mysqlTableCollector{}
+  node.Accept(collector)
+
+  for _, table := range collector.tables {
+    if table != ps.Name || ps.StmtType != TABLE && ps.StmtType != VIEW {
+      appendDependency(ps, TABLE, table)
+    }
+  }
+
+  ps.HasName = ps.Name != ""
+}
+
+func (mysqlDialect) ParseStmts(code string) ([]*ParsedStmt, error) {
+  var ps []*ParsedStmt
+
+  nodes, err := parseMysql(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, node := range nodes {
+    dp, err := deparseMysqlStmt(node)
+
+    if err != nil {
+      return nil, err
+    }
+
+    nps := &ParsedStmt{
+      Deparsed: dp,
+      Hash: HashString(dp),
+      StmtType: UNKNOWN_TYPE,
+      Dependencies: make([]*Dependency, 0),
+      Status: UNKNOWN,
+    }
+
+    hydrateMysqlStmt(node, nps)
+
+    ps = append(ps, nps)
+  }
+
+  return ps, nil
+}
+
+func getMysqlDestructiveReason(node ast.StmtNode) string {
+  switch n := node.(type) {
+    case *ast.DropTableStmt, *ast.DropIndexStmt, *ast.DropDatabaseStmt, *ast.DropSequenceStmt, *ast.DropProcedureStmt: {
+      return "DROP"
+    }
+
+    case *ast.TruncateTableStmt: {
+      return "TRUNCATE"
+    }
+
+    case *ast.AlterTableStmt: {
+      for _, spec := range n.Specs {
+        if spec.Tp == ast.AlterTableDropColumn {
+          return "ALTER TABLE ... DROP COLUMN"
+        }
+      }
+    }
+
+    case *ast.DeleteStmt: {
+      if n.Where == nil {
+        return "DELETE without a WHERE clause"
+      }
+    }
+  }
+
+  return ""
+}
+
+func (mysqlDialect) DestructiveStmts(file string, code string) ([]string, error) {
+  var destructive []string
+
+  nodes, err := parseMysql(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  // The parser keeps the text of each statement but not where it starts.
+  offset := 0
+
+  for _, node := range nodes {
+    if i := strings.Index(code[offset:], node.Text()); i >= 0 {
+      offset += i
+    }
+
+    if reason := getMysqlDestructiveReason(node); reason != "" {
+      destructive = append(destructive, fmt.Sprintf("%s:%d %s", file, lineOfOffset(code, offset), reason))
+    }
+  }
+
+  return destructive, nil
+}
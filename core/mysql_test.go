@@ -0,0 +1,131 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMysqlStmts(t *testing.T) {
+  schema := `
+    CREATE TABLE person_address (
+      id int primary key,
+      person_id int,
+      FOREIGN KEY (person_id) REFERENCES person(id)
+    );
+
+    CREATE INDEX person_address_person_idx ON person_address (person_id);
+
+    CREATE TABLE person (id int primary key, name varchar(100));
+
+    CREATE VIEW person_with_address AS SELECT p.name FROM person p JOIN person_address a ON a.person_id = p.id;
+  `
+
+  t.Run("mysql statements", func(t *testing.T) {
+    stmts, e := mysqlDialect{}.ParseStmts(schema)
+    perr(e)
+    hydrateDependencies(stmts)
+
+    var order []string
+
+    for _, stmt := range sortStmtsByPriority(stmts) {
+      order = append(order, stmt.Name)
+    }
+
+    correct := []string{ "person", "person_address", "person_address.person_address_person_idx", "person_with_address" }
+
+    if !reflect.DeepEqual(order, correct) {
+      test_failed(t, order, correct)
+    }
+
+    if stmts[2].StmtType != TABLE || stmts[1].StmtType != INDEX || stmts[3].StmtType != VIEW {
+      test_failed(t, []StmtType{ stmts[2].StmtType, stmts[1].StmtType, stmts[3].StmtType }, []StmtType{ TABLE, INDEX, VIEW })
+    }
+
+    deparsed := "CREATE TABLE `person` (`id` INT PRIMARY KEY,`name` VARCHAR(100));"
+
+    if stmts[2].Deparsed != deparsed {
+      test_failed(t, stmts[2].Deparsed, deparsed)
+    }
+  })
+}
+
+func TestMysqlIndexNames(t *testing.T) {
+  t.Run("mysql index names", func(t *testing.T) {
+    ctx := &Context{ Dialect: mysqlDialect{} }
+
+    stmts, e := mysqlDialect{}.ParseStmts("CREATE INDEX created_idx ON person (created); CREATE INDEX created_idx ON pet (created);")
+    perr(e)
+
+    var names []string
+
+    for _, stmt := range stmts {
+      qualifyStmtName(ctx, stmt)
+      names = append(names, stmt.Name)
+    }
+
+    if correct := []string{ "person.created_idx", "pet.created_idx" }; !reflect.DeepEqual(names, correct) {
+      test_failed(t, names, correct)
+    }
+  })
+}
+
+func TestMysqlDropDependencies(t *testing.T) {
+  t.Run("mysql drop index", func(t *testing.T) {
+    stmts, e := mysqlDialect{}.ParseStmts("DROP INDEX created_idx ON pet;")
+    perr(e)
+
+    var checked []Dependency
+
+    for _, dep := range stmts[0].Dependencies {
+      checked = append(checked, *dep)
+    }
+
+    correct := []Dependency{ *buildDependency(INDEX, "pet.created_idx"), *buildDependency(TABLE, "pet") }
+
+    if !reflect.DeepEqual(checked, correct) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestMysqlRebind(t *testing.T) {
+  t.Run("mysql rebind", func(t *testing.T) {
+    ctx := &Context{ Dialect: mysqlDialect{} }
+
+    query := bookkeepingSql(ctx, "delete from {statements} where stmt_name=$1 and stmt_type=$2")
+    correct := "delete from schemaflow_statements where stmt_name=? and stmt_type=?"
+
+    if query != correct {
+      test_failed(t, query, correct)
+    }
+  })
+}
+
+func TestMysqlDestructiveStmts(t *testing.T) {
+  migration := `
+    DROP TABLE old_person;
+    TRUNCATE person;
+    ALTER TABLE person DROP COLUMN name;
+    ALTER TABLE person ADD COLUMN age int;
+    DELETE FROM person;
+    DELETE FROM person WHERE id = 1;
+    DROP INDEX person_age_idx ON person;
+  `
+
+  t.Run("mysql destructive statements", func(t *testing.T) {
+    checked, e := mysqlDialect{}.DestructiveStmts("0001.sql", migration)
+    perr(e)
+
+    correct := []string{
+      "0001.sql:2 DROP",
+      "0001.sql:3 TRUNCATE",
+      "0001.sql:4 ALTER TABLE ... DROP COLUMN",
+      "0001.sql:6 DELETE without a WHERE clause",
+      "0001.sql:8 DROP",
+    }
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
@@ -4,7 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"slices"
+	"strings"
+	"time"
 )
 
 const HELP_TEXT = `SchemaFlow
@@ -13,22 +17,63 @@ Usage
   schemaflow [options] [cmd]
 
 Options
-  --host              Database host name
-  --port              Database port number
-  --user              Database user
-  --password          Database user password
-  --db                Database name
+  --config            Path of the config file. Defaults to schemaflow.yaml, schemaflow.yml or schemaflow.toml in the current directory
+  --env               The environment section of the config file to use on top of its default section (e.g. dev, staging, prod)
+  --url               postgres:// connection URL. Defaults to $DATABASE_URL. --host, --port, --user, --password and --db override its parts
+  --host              Database host name (default $PGHOST, or localhost)
+  --port              Database port number (default $PGPORT, or 5432, or 3306 for mysql)
+  --user              Database user (default $PGUSER, or the current user)
+  --password          Database user password. Prefer --password-file, --password-prompt, $PGPASSWORD or ~/.pgpass to keep it out of shell history
+  --password-file     Read the password from this file, e.g. a Docker or Kubernetes secret. A trailing newline is ignored
+  --password-prompt   Ask for the password on the terminal, without echoing it
+  --db                Database name (default $PGDATABASE), or the path of the database file for sqlite
   --sql-path          The path to your database schema files
   --migrations-path   The path where your migration files will be generated.
-  --ssl               Enable ssl mode
+  --ssl               Shorthand for --sslmode=require
+  --sslmode           disable, allow, prefer, require, verify-ca or verify-full (default $PGSSLMODE, or disable)
+  --sslrootcert       CA certificate file used to verify the server with verify-ca and verify-full (default $PGSSLROOTCERT)
+  --sslcert           Client certificate file (default $PGSSLCERT, or ~/.postgresql/postgresql.crt)
+  --sslkey            Client private key file (default $PGSSLKEY, or ~/.postgresql/postgresql.key)
+  --sslpassword       Password of an encrypted --sslkey
+  --dialect           The database being managed: postgres (default), mysql or sqlite
+  --through           The last migration number to fold into the baseline when running squash (e.g. 0150)
+  --allow-destructive Let migrate run migrations that drop, truncate, or delete without a WHERE clause
+  --dry-run           Show the migrations migrate would execute and the locks each statement takes, without executing them
+  --lint-disable      Comma separated list of lint rules to skip
+  --snapshot          Write a sorted snapshot of every object in the database to this file after migrate (e.g. ./schema.snapshot.sql)
+  --log-format        text (default) or json. json writes one object per line to stderr, for log pipelines
+  --wait              Keep retrying the connection, with exponential backoff, while the database is starting up
+  --wait-timeout      How long --wait keeps retrying before giving up (default 1m)
+  --bookkeeping-schema The schema holding SchemaFlow's migrations and statements tables (default schemaflow). Give each project sharing a database its own
+  --table-prefix      Prefix for the names of SchemaFlow's tables (e.g. platform_ for platform_migrations)
+  --search-path       Comma separated schemas that unqualified names in --sql-path resolve against, as with search_path. Objects are created in the first (default public)
+  --role              Run each migration after SET ROLE to this role, so it owns the objects it creates. "-- schemaflow:role <name>" in a migration overrides it
+  --check-owner       Make fails when --role can't create every new object, i.e. wouldn't own it
+  --timeout           Roll back and exit when the command takes longer than this (e.g. 30s, 5m). Interrupting with Ctrl-C or SIGTERM also rolls back
+  --databases         Comma separated list of databases to migrate in parallel, each in its own transaction, instead of --db
+  --databases-file    File listing databases to migrate, one per line
+  --databases-query   Query run against --db whose first column lists databases to migrate (e.g. "select name from tenants")
+  --schemas           Comma separated list of schemas in --db to migrate, each with search_path set to it, for one schema per tenant
+  --schemas-pattern   Migrate every schema whose name matches this LIKE pattern (e.g. tenant_%)
+  --schemas-query     Query run against --db whose first column lists schemas to migrate (e.g. "select schema_name from tenants")
+  --concurrency       Number of databases or schemas migrated at once (default 4)
+  --continue-on-error Keep migrating the remaining databases or schemas after one fails
 
 Commands
   make          Compute schema changes in --sql-path and generate a new migration file. New migrations will be placed in the --migrations-path
   migrate       Run unexecuted migration files in the --migrations-path
+  check         Exit with a non-zero status, without writing anything, when --sql-path has changes without a migration, a migration is unresolved, an executed migration was tampered with, or a schema file fails to parse
+  lint          Report risky statements and the locks they take in unexecuted migrations. Exits with a non-zero status when anything is found
+  squash        Replace every migration up to and including --through with a single baseline migration
   help          Open this menu
 
 Examples
   schemaflow --host=127.0.0.1 --port=5432 --user=postgres --password=postgres --db=example --sql-path=/path/to/my/schema/sql --migrations-path=./project/migrations make
+  schemaflow --db=example --sql-path=/path/to/my/schema/sql --migrations-path=./project/migrations check
+  schemaflow --db=example --migrations-path=./project/migrations --through=0150 squash
+  schemaflow --env=staging migrate
+  schemaflow --databases-file=./tenants.txt --concurrency=8 --continue-on-error migrate
+  schemaflow --db=app --schemas-pattern=tenant_% migrate
   schemaflow help
 `
 
@@ -38,18 +83,59 @@ func showHelp() {
 }
 
 func ParseArgs() *Context {
-  host := flag.String("host", "127.0.0.1", "host")
-  port := flag.Int("port", 5432, "port") 
-  user := flag.String("user", "postgres", "user")
-  password := flag.String("password", "postgres", "password")
+  db_url := flag.String("url", "", "url")
+  host := flag.String("host", "", "host")
+  port := flag.Int("port", 0, "port") 
+  user := flag.String("user", "", "user")
+  password := flag.String("password", "", "password")
+  password_file := flag.String("password-file", "", "password-file")
+  // Read through getPasswordSource.
+  flag.Bool("password-prompt", false, "password-prompt")
   db_name := flag.String("db", "", "db") 
   ssl := flag.Bool("ssl", false, "ssl")
+  ssl_mode := flag.String("sslmode", "", "sslmode")
+  ssl_root_cert := flag.String("sslrootcert", "", "sslrootcert")
+  ssl_cert := flag.String("sslcert", "", "sslcert")
+  ssl_key := flag.String("sslkey", "", "sslkey")
+  ssl_password := flag.String("sslpassword", "", "sslpassword")
+  dialect_name := flag.String("dialect", DIALECT_POSTGRES, "dialect")
 
   sql_path := flag.String("sql-path", "./", "sql-path")
   migration_path := flag.String("migrations-path", "./schemaflow_migrations", "migrations-path")
+  snapshot_path := flag.String("snapshot", "", "snapshot")
+  through := flag.String("through", "", "through")
+  lint_disable := flag.String("lint-disable", "", "lint-disable")
+  dry_run := flag.Bool("dry-run", false, "dry-run")
+  allow_destructive := flag.Bool("allow-destructive", false, "allow-destructive")
+  timeout := flag.Duration("timeout", 0, "timeout")
+  wait := flag.Bool("wait", false, "wait")
+  wait_timeout := flag.Duration("wait-timeout", time.Minute, "wait-timeout")
+  config_path := flag.String("config", "", "config")
+  env := flag.String("env", "", "env")
+  log_format := flag.String("log-format", "text", "log-format")
+  databases := flag.String("databases", "", "databases")
+  databases_file := flag.String("databases-file", "", "databases-file")
+  databases_query := flag.String("databases-query", "", "databases-query")
+  concurrency := flag.Int("concurrency", DEFAULT_CONCURRENCY, "concurrency")
+  continue_on_error := flag.Bool("continue-on-error", false, "continue-on-error")
+  schemas := flag.String("schemas", "", "schemas")
+  bookkeeping_schema := flag.String("bookkeeping-schema", DEFAULT_BOOKKEEPING_SCHEMA, "bookkeeping-schema")
+  table_prefix := flag.String("table-prefix", "", "table-prefix")
+  schemas_pattern := flag.String("schemas-pattern", "", "schemas-pattern")
+  schemas_query := flag.String("schemas-query", "", "schemas-query")
+  role := flag.String("role", "", "role")
+  check_owner := flag.Bool("check-owner", false, "check-owner")
+  search_path := flag.String("search-path", strings.Join(DEFAULT_SEARCH_PATH, ","), "search-path")
 
   flag.Parse()
 
+  // Visited before the config file sets any flags.
+  cli_password_flags := getPasswordFlagsSet(flag.CommandLine)
+
+  if err := loadConfig(flag.CommandLine, *config_path, *env); err != nil {
+    log.Fatalln(err)
+  }
+
   actions := flag.Args()
 
   if len(actions) == 0 {
@@ -64,10 +150,6 @@ func ParseArgs() *Context {
 
   var action_enum ActionType
 
-  if *db_name == "" {
-    log.Fatalln("'db' is required.")
-  }
-
   if action == "" {
     log.Fatalln("'action' is required.")
   } else if action == ACTION_CLEAN {
@@ -76,24 +158,189 @@ func ParseArgs() *Context {
     action_enum = MIGRATE 
   } else if action == ACTION_MAKE_MIGRATIONS {
     action_enum = MAKEMIGRATIONS
+  } else if action == ACTION_CHECK {
+    action_enum = CHECK
+  } else if action == ACTION_LINT {
+    action_enum = LINT
+  } else if action == ACTION_SQUASH {
+    action_enum = SQUASH
+
+    if *through == "" {
+      log.Fatalln("'through' is required.")
+    }
   } else {
     showHelp()
   }
 
+  switch *log_format {
+    case "text": {}
+
+    case "json": {
+      // Also routes the log package through the handler.
+      slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+    }
+
+    default: {
+      log.Fatalf("Unknown log format '%s'. Use text or json.\n", *log_format)
+    }
+  }
+
+  if *dry_run && action_enum != MIGRATE {
+    log.Fatalln("'dry-run' can only be used with migrate.")
+  }
+
+  if *check_owner {
+    if action_enum != MAKEMIGRATIONS {
+      log.Fatalln("'check-owner' can only be used with make.")
+    }
+
+    if *role == "" {
+      log.Fatalln("'check-owner' requires 'role'.")
+    }
+  }
+
+  password_source, err := getPasswordSource(cli_password_flags, getPasswordFlagsSet(flag.CommandLine))
+
+  if err != nil {
+    log.Fatalln(err)
+  }
+
+  switch password_source {
+    case "password-file": {
+      read, err := ReadPasswordFile(*password_file)
+
+      if err != nil {
+        log.Fatalln(err)
+      }
+
+      *password = read
+    }
+
+    case "password-prompt": {
+      typed, err := PromptPassword("Password: ")
+
+      if err != nil {
+        log.Fatalln(err)
+      }
+
+      *password = typed
+    }
+  }
+
   ctx := new(Context);
 
+  dialect, err := GetDialect(*dialect_name)
+
+  if err != nil {
+    log.Fatalln(err)
+  }
+
+  ctx.Dialect = dialect
+
   ctx.DbContext = &DbContext{
-    *host,
-    *port,
-    *user,
-    *password,
-    *db_name,
-    *ssl,
+    PgUrl: *db_url,
+    PgHost: *host,
+    PgPort: *port,
+    PgUser: *user,
+    PgPassword: *password,
+    PgDbName: *db_name,
+    PgSSL: *ssl,
+    PgSSLMode: *ssl_mode,
+    PgSSLRootCert: *ssl_root_cert,
+    PgSSLCert: *ssl_cert,
+    PgSSLKey: *ssl_key,
+    PgSSLPassword: *ssl_password,
   }
 
   ctx.SqlPath = *sql_path
   ctx.Action = action_enum
   ctx.MigrationPath = *migration_path
+  ctx.SnapshotPath = *snapshot_path
+  ctx.SquashThrough = *through
+  ctx.DryRun = *dry_run
+  ctx.AllowDestructive = *allow_destructive
+  ctx.Timeout = *timeout
+  ctx.Role = *role
+  ctx.CheckOwner = *check_owner
+
+  if *wait {
+    ctx.WaitTimeout = *wait_timeout
+  }
+  ctx.BookkeepingSchema = *bookkeeping_schema
+  ctx.TablePrefix = *table_prefix
+
+  if err := validateBookkeepingNames(ctx); err != nil {
+    log.Fatalln(err)
+  }
+
+  for _, rule := range strings.Split(*lint_disable, ",") {
+    rule = strings.TrimSpace(rule)
+
+    if rule == "" {
+      continue
+    }
+
+    if !slices.Contains(LINT_RULES, rule) {
+      log.Fatalf("Unknown lint rule '%s'. Available rules: %s\n", rule, strings.Join(LINT_RULES, ", "))
+    }
+
+    ctx.LintDisabled = append(ctx.LintDisabled, rule)
+  }
+
+  for _, database := range strings.Split(*databases, ",") {
+    if database = strings.TrimSpace(database); database != "" {
+      ctx.Databases = append(ctx.Databases, database)
+    }
+  }
+
+  if *databases_file != "" {
+    listed, err := ReadDatabaseList(*databases_file)
+
+    if err != nil {
+      log.Fatalln(err)
+    }
+
+    ctx.Databases = append(ctx.Databases, listed...)
+  }
+
+  for _, schema := range strings.Split(*schemas, ",") {
+    if schema = strings.TrimSpace(schema); schema != "" {
+      ctx.Schemas = append(ctx.Schemas, schema)
+    }
+  }
+
+  for _, schema := range strings.Split(*search_path, ",") {
+    if schema = strings.TrimSpace(schema); schema != "" {
+      ctx.SearchPath = append(ctx.SearchPath, schema)
+    }
+  }
+
+  ctx.DatabasesQuery = *databases_query
+  ctx.SchemasPattern = *schemas_pattern
+  ctx.SchemasQuery = *schemas_query
+  ctx.Concurrency = *concurrency
+  ctx.ContinueOnError = *continue_on_error
+
+  migrate_databases := len(ctx.Databases) > 0 || ctx.DatabasesQuery != ""
+  migrate_schemas := len(ctx.Schemas) > 0 || ctx.SchemasPattern != "" || ctx.SchemasQuery != ""
+
+  if migrate_databases && migrate_schemas {
+    log.Fatalln("Databases and schemas can't be migrated in the same run.")
+  }
+
+  if migrate_databases || migrate_schemas {
+    if action_enum != MIGRATE {
+      log.Fatalln("'databases', 'databases-file', 'databases-query', 'schemas', 'schemas-pattern' and 'schemas-query' can only be used with migrate.")
+    }
+
+    if *snapshot_path != "" {
+      log.Fatalln("'snapshot' can't be used when migrating more than one database or schema.")
+    }
+
+    if *concurrency < 1 {
+      log.Fatalln("'concurrency' must be at least 1.")
+    }
+  }
 
   return ctx
 }
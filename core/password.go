@@ -0,0 +1,92 @@
+package core
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Reads a password from a file such as a Docker or Kubernetes secret. The
+// trailing newline most editors and echo add is not part of the password.
+func ReadPasswordFile(path string) (string, error) {
+  data, err := os.ReadFile(path)
+
+  if err != nil {
+    return "", err
+  }
+
+  password := strings.TrimRight(string(data), "\r\n")
+
+  if password == "" {
+    return "", fmt.Errorf("password file %s is empty", path)
+  }
+
+  return password, nil
+}
+
+// Asks for a password on the terminal without echoing it. The terminal is
+// used even when stdin is redirected.
+func PromptPassword(prompt string) (string, error) {
+  tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+
+  if err != nil {
+    tty = os.Stdin
+  } else {
+    defer tty.Close()
+  }
+
+  if !term.IsTerminal(int(tty.Fd())) {
+    return "", fmt.Errorf("prompting for a password needs a terminal")
+  }
+
+  fmt.Fprint(os.Stderr, prompt)
+  password, err := term.ReadPassword(int(tty.Fd()))
+  fmt.Fprintln(os.Stderr)
+
+  if err != nil {
+    return "", err
+  }
+
+  return string(password), nil
+}
+
+// The flags that give the password. Only one of them may be used.
+var PASSWORD_FLAGS = []string{ "password", "password-file", "password-prompt" }
+
+// The password flags set in flags, leaving out empty and false ones.
+func getPasswordFlagsSet(flags *flag.FlagSet) []string {
+  var set []string
+
+  flags.Visit(func(f *flag.Flag) {
+    if value := f.Value.String(); slices.Contains(PASSWORD_FLAGS, f.Name) && value != "" && value != "false" {
+      set = append(set, f.Name)
+    }
+  })
+
+  return set
+}
+
+// The flag the password comes from, or "" when none gives it. cli_set are the
+// password flags given on the command line, which replace any password in the
+// config file, and all_set include the config file's.
+func getPasswordSource(cli_set []string, all_set []string) (string, error) {
+  sources := cli_set
+
+  if len(sources) == 0 {
+    sources = all_set
+  }
+
+  if len(sources) > 1 {
+    return "", fmt.Errorf("only one of 'password', 'password-file' and 'password-prompt' can be used, got %s", strings.Join(sources, ", "))
+  }
+
+  if len(sources) == 0 {
+    return "", nil
+  }
+
+  return sources[0], nil
+}
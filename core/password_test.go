@@ -0,0 +1,103 @@
+package core
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPasswordFile(t *testing.T) {
+  dir := t.TempDir()
+
+  tests := []struct {
+    name string
+    contents string
+    correct string
+  }{
+    { "plain", "s3cret", "s3cret" },
+    { "trailing newline", "s3cret\n", "s3cret" },
+    { "windows newline", "s3cret\r\n", "s3cret" },
+    { "surrounding spaces are kept", " s3cret \n", " s3cret " },
+  }
+
+  for i, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      path := filepath.Join(dir, string(rune('a' + i)))
+      perr(os.WriteFile(path, []byte(test.contents), 0600))
+
+      got, e := ReadPasswordFile(path)
+      perr(e)
+
+      if got != test.correct {
+        test_failed(t, got, test.correct)
+      }
+    })
+  }
+
+  t.Run("empty file", func(t *testing.T) {
+    path := filepath.Join(dir, "empty")
+    perr(os.WriteFile(path, []byte("\n"), 0600))
+
+    if _, e := ReadPasswordFile(path); e == nil {
+      t.Errorf("expected an error for an empty password file")
+    }
+  })
+
+  t.Run("missing file", func(t *testing.T) {
+    if _, e := ReadPasswordFile(filepath.Join(dir, "missing")); e == nil {
+      t.Errorf("expected an error for a missing password file")
+    }
+  })
+}
+
+func TestPasswordSource(t *testing.T) {
+  parse := func(args []string, config map[string]string) (string, error) {
+    flags := flag.NewFlagSet("schemaflow", flag.ContinueOnError)
+    flags.String("password", "", "password")
+    flags.String("password-file", "", "password-file")
+    flags.Bool("password-prompt", false, "password-prompt")
+    perr(flags.Parse(args))
+
+    cli_set := getPasswordFlagsSet(flags)
+    perr(applyConfigOptions(flags, config))
+
+    return getPasswordSource(cli_set, getPasswordFlagsSet(flags))
+  }
+
+  tests := []struct {
+    name string
+    args []string
+    config map[string]string
+    correct string
+  }{
+    { "none", nil, nil, "" },
+    { "command line", []string{ "--password-file=/run/secrets/db" }, nil, "password-file" },
+    { "config file", nil, map[string]string{ "password": "s3cret" }, "password" },
+    { "command line replaces config file", []string{ "--password-prompt" }, map[string]string{ "password": "s3cret" }, "password-prompt" },
+    { "false prompt", []string{ "--password-prompt=false", "--password=s3cret" }, nil, "password" },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      got, e := parse(test.args, test.config)
+      perr(e)
+
+      if got != test.correct {
+        test_failed(t, got, test.correct)
+      }
+    })
+  }
+
+  t.Run("two on the command line", func(t *testing.T) {
+    if _, e := parse([]string{ "--password=s3cret", "--password-prompt" }, nil); e == nil {
+      t.Errorf("expected an error for two password sources")
+    }
+  })
+
+  t.Run("two in the config file", func(t *testing.T) {
+    if _, e := parse(nil, map[string]string{ "password": "s3cret", "password-file": "/run/secrets/db" }); e == nil {
+      t.Errorf("expected an error for two password sources")
+    }
+  })
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+type postgresDialect struct {}
+
+func (postgresDialect) Name() string {
+  return DIALECT_POSTGRES
+}
+
+// The connection string lib/pq opens. The service was resolved by
+// buildPostgresConnString, so an empty one stops lib/pq from looking up
+// PGSERVICE again, without PGSYSCONFDIR and over the options given.
+func getPostgresDsn(db_ctx *DbContext) (string, error) {
+  db_conn_str, err := buildPostgresConnString(db_ctx)
+
+  if err != nil {
+    return "", err
+  }
+
+  return db_conn_str + " service=''", nil
+}
+
+func (postgresDialect) Open(ctx context.Context, db_ctx *DbContext) (*sql.DB, error) {
+  dsn, err := getPostgresDsn(db_ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return openDb(ctx, "postgres", dsn)
+}
+
+func (postgresDialect) MigrationSchema() string {
+  return MIGRATION_SCHEMA
+}
+
+func (postgresDialect) Table(schema string, name string) string {
+  return schema + "." + name
+}
+
+func (postgresDialect) Rebind(query string) string {
+  return query
+}
+
+func (postgresDialect) ParseStmts(code string) ([]*ParsedStmt, error) {
+  parsed, err := parseSql(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return extractStmts(nil, parsed)
+}
+
+func (postgresDialect) DestructiveStmts(file string, code string) ([]string, error) {
+  stmts, err := parseMigrationStmts(file, code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return getDestructiveStmts(stmts), nil
+}
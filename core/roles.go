@@ -0,0 +1,163 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// "-- schemaflow:role app_owner" runs the migration as app_owner in place of
+// --role.
+const DIRECTIVE_ROLE = "role"
+
+// Statement types whose objects are owned by the role that creates them.
+var OWNED_STMT_TYPES = []StmtType{
+  SCHEMA, FUNCTION, PROCEDURE, AGGREGATE, DOMAIN, TYPE, ENUM, COLLATION,
+  TABLE, FOREIGN_TABLE, VIEW, MATERIALIZED_VIEW, SEQUENCE,
+}
+
+// The role a migration runs as: the last role directive in code, or ctx.Role.
+func getMigrationRole(ctx *Context, code string) string {
+  roles := getDirectiveValues(code, DIRECTIVE_ROLE)
+
+  if len(roles) > 0 && roles[len(roles) - 1] != "" {
+    return roles[len(roles) - 1]
+  }
+
+  return ctx.Role
+}
+
+// Switches ctx.DbTx to role until resetRole. Does nothing when role is empty.
+func setRole(ctx *Context, role string) error {
+  if role == "" {
+    return nil
+  }
+
+  if err := requirePostgres(ctx, "Running migrations as a role"); err != nil {
+    return err
+  }
+
+  _, err := ctx.DbTx.ExecContext(getCtx(ctx), "set local role " + pq.QuoteIdentifier(role))
+  return err
+}
+
+// Switches back to the login user, who owns the bookkeeping tables.
+func resetRole(ctx *Context, role string) error {
+  if role == "" {
+    return nil
+  }
+
+  _, err := ctx.DbTx.ExecContext(getCtx(ctx), "reset role")
+  return err
+}
+
+// The schema in a qualified name, or "" when it isn't qualified.
+func getNameSchema(name string) string {
+  parts := strings.Split(name, ".")
+
+  if len(parts) < 2 {
+    return ""
+  }
+
+  return parts[len(parts) - 2]
+}
+
+// Checks that ctx.Role can create every NEW object in ctx.Stmts, so that
+// migrating as ctx.Role leaves it owning all of them.
+func checkOwners(ctx *Context) error {
+  if err := requirePostgres(ctx, "Checking owners"); err != nil {
+    return err
+  }
+
+  role := ctx.Role
+
+  if role == "" {
+    return fmt.Errorf("checking owners needs a role")
+  }
+
+  var exists bool
+
+  if err := ctx.DbTx.QueryRowContext(getCtx(ctx), "select exists(select 1 from pg_roles where rolname = $1)", role).Scan(&exists); err != nil {
+    return err
+  }
+
+  if !exists {
+    return fmt.Errorf("role '%s' does not exist", role)
+  }
+
+  // Unqualified names are created in the first schema on the role's
+  // search_path, which may be "$user".
+  var current_schema sql.NullString
+
+  if err := setRole(ctx, role); err != nil {
+    return err
+  }
+
+  if err := ctx.DbTx.QueryRowContext(getCtx(ctx), "select current_schema()").Scan(&current_schema); err != nil {
+    return err
+  }
+
+  if err := resetRole(ctx, role); err != nil {
+    return err
+  }
+
+  var problems []string
+
+  for _, stmt := range *ctx.Stmts {
+    if stmt.Status != NEW || !slices.Contains(OWNED_STMT_TYPES, stmt.StmtType) {
+      continue
+    }
+
+    var allowed bool
+
+    if stmt.StmtType == SCHEMA {
+      if err := ctx.DbTx.QueryRowContext(getCtx(ctx), "select has_database_privilege($1, current_database(), 'CREATE')", role).Scan(&allowed); err != nil {
+        return err
+      }
+
+      if !allowed {
+        problems = append(problems, fmt.Sprintf("%s (no CREATE on the database)", stmt.Name))
+      }
+
+      continue
+    }
+
+    // The migration creates the object under the name it was written with,
+    // not the one qualified with --search-path.
+    name := stmt.Name
+
+    if stmt.WrittenName != "" {
+      name = stmt.WrittenName
+    }
+
+    schema := getNameSchema(name)
+
+    if schema == "" {
+      if !current_schema.Valid {
+        problems = append(problems, fmt.Sprintf("%s (no schema on the role's search_path)", name))
+        continue
+      }
+
+      schema = current_schema.String
+    }
+
+    // A schema that doesn't exist yet is created by the same migration, so
+    // the role owns it.
+    if err := ctx.DbTx.QueryRowContext(getCtx(ctx), "select coalesce((select has_schema_privilege($1, oid, 'CREATE') from pg_namespace where nspname = $2), true)", role, schema).Scan(&allowed); err != nil {
+      return err
+    }
+
+    if !allowed {
+      problems = append(problems, fmt.Sprintf("%s (no CREATE on schema %s)", name, schema))
+    }
+  }
+
+  if len(problems) > 0 {
+    return &OwnerError { role, problems }
+  }
+
+  return nil
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrationRole(t *testing.T) {
+  tests := []struct {
+    name string
+    role string
+    code string
+    correct string
+  }{
+    { "no role", "", "CREATE TABLE person (id int);", "" },
+    { "role from --role", "app_owner", "CREATE TABLE person (id int);", "app_owner" },
+    { "directive overrides --role", "app_owner", "-- schemaflow:role reporting_owner\nCREATE TABLE report (id int);", "reporting_owner" },
+    { "last directive wins", "", "-- schemaflow:role first\n-- schemaflow:role second\n", "second" },
+    { "empty directive", "app_owner", "-- schemaflow:role\n", "app_owner" },
+  }
+
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if got := getMigrationRole(&Context{ Role: test.role }, test.code); got != test.correct {
+        test_failed(t, got, test.correct)
+      }
+    })
+  }
+
+  t.Run("postgres only", func(t *testing.T) {
+    if e := setRole(&Context{ Dialect: sqliteDialect{} }, "app_owner"); e == nil {
+      t.Errorf("expected an error for a dialect without roles")
+    }
+  })
+
+  t.Run("schema of a name", func(t *testing.T) {
+    for name, correct := range map[string]string{ "person": "", "app.person": "app", "db.app.person": "app" } {
+      if got := getNameSchema(name); got != correct {
+        test_failed(t, got, correct)
+      }
+    }
+  })
+}
+
+func TestBaselineRole(t *testing.T) {
+  t.Run("shared role is carried", func(t *testing.T) {
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      "0000.sql": { Data: []byte("-- schemaflow:role app_owner\nCREATE TABLE person (id int);") },
+      "0001.sql": { Data: []byte("-- schemaflow:role app_owner\nCREATE TABLE pet (id int);") },
+    } }
+
+    baseline, e := buildBaseline(ctx, []string{ "0000.sql", "0001.sql" })
+    perr(e)
+
+    if got := getMigrationRole(&Context{}, baseline); got != "app_owner" {
+      test_failed(t, got, "app_owner")
+    }
+  })
+
+  t.Run("different roles", func(t *testing.T) {
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      "0000.sql": { Data: []byte("CREATE TABLE person (id int);") },
+      "0001.sql": { Data: []byte("-- schemaflow:role app_owner\nCREATE TABLE pet (id int);") },
+    } }
+
+    if _, e := buildBaseline(ctx, []string{ "0000.sql", "0001.sql" }); e == nil {
+      t.Errorf("expected an error for migrations that run as different roles")
+    }
+  })
+}
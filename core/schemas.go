@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Sets search_path for the rest of ctx.DbTx. When ctx.Schema is set it's the
+// schema followed by public, so extensions installed there keep resolving.
+// Otherwise it's getSearchPath, so that unqualified objects are created in
+// the schema make recorded them under.
+func setSearchPath(ctx *Context) error {
+  if ctx.Schema != "" {
+    if err := requirePostgres(ctx, "Migrating schemas"); err != nil {
+      return err
+    }
+
+    _, err := ctx.DbTx.ExecContext(getCtx(ctx), fmt.Sprintf("set local search_path to %s, public", pq.QuoteIdentifier(ctx.Schema)))
+    return err
+  }
+
+  if !isSearchPathUsed(ctx) {
+    return nil
+  }
+
+  var schemas []string
+
+  for _, schema := range getSearchPath(ctx) {
+    schemas = append(schemas, pq.QuoteIdentifier(schema))
+  }
+
+  _, err := ctx.DbTx.ExecContext(getCtx(ctx), "set local search_path to " + strings.Join(schemas, ", "))
+  return err
+}
+
+// The schemas in ctx.Schemas, matching ctx.SchemasPattern, and returned by
+// ctx.SchemasQuery, each listed once.
+func getSchemas(ctx *Context) ([]string, error) {
+  schemas := slices.Clone(ctx.Schemas)
+
+  if ctx.SchemasPattern != "" {
+    matched, err := queryNames(ctx, "select nspname from pg_namespace where nspname like $1 order by nspname", ctx.SchemasPattern)
+
+    if err != nil {
+      return nil, fmt.Errorf("listing schemas: %w", err)
+    }
+
+    schemas = append(schemas, matched...)
+  }
+
+  if ctx.SchemasQuery != "" {
+    queried, err := queryNames(ctx, ctx.SchemasQuery)
+
+    if err != nil {
+      return nil, fmt.Errorf("listing schemas: %w", err)
+    }
+
+    schemas = append(schemas, queried...)
+  }
+
+  var unique []string
+
+  for _, schema := range schemas {
+    if !slices.Contains(unique, schema) {
+      unique = append(unique, schema)
+    }
+  }
+
+  return unique, nil
+}
+
+// Creates the bookkeeping tables in their own transaction, so that the
+// transactions of MigrateSchemas don't race to create them.
+func initializeBookkeeping(ctx *Context) error {
+  db, err := CreateDbConnections(ctx)
+
+  if err != nil {
+    return err
+  }
+
+  defer db.Close()
+
+  tx, err := db.BeginTx(getCtx(ctx), nil)
+
+  if err != nil {
+    return err
+  }
+
+  target := *ctx
+  target.DbTx = tx
+
+  if err := initializeMigrationsSchema(&target); err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  return tx.Commit()
+}
+
+// Applies every pending migration once per schema in getSchemas, each in its
+// own transaction with search_path set to the schema. The migrations table
+// records the schema of each execution, so schemas are migrated
+// independently. Results are in the order of the list.
+func MigrateSchemas(ctx *Context) ([]*DatabaseResult, error) {
+  if err := requirePostgres(ctx, "Migrating schemas"); err != nil {
+    return nil, err
+  }
+
+  schemas, err := getSchemas(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  if len(schemas) == 0 {
+    return nil, fmt.Errorf("there are no schemas to migrate")
+  }
+
+  if !ctx.DryRun {
+    if err := initializeBookkeeping(ctx); err != nil {
+      return nil, err
+    }
+  }
+
+  getLogger(ctx).Info("Migrating schemas", "schemas", len(schemas), "concurrency", getConcurrency(ctx))
+
+  var results []*DatabaseResult
+
+  for _, schema := range schemas {
+    results = append(results, &DatabaseResult{ Schema: schema })
+  }
+
+  migrateEach(ctx, results)
+  return results, nil
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Unqualified names in the schema files resolve against these schemas, the
+// way postgres resolves them against search_path. Objects are created in the
+// first one.
+var DEFAULT_SEARCH_PATH = []string{ "public" }
+
+// Statement types whose objects live in a schema, so that person and
+// public.person can name the same object.
+var SCHEMA_QUALIFIED_STMT_TYPES = []StmtType{
+  FUNCTION, PROCEDURE, AGGREGATE, DOMAIN, TYPE, GENERIC_TYPE, ENUM, COLLATION,
+  TABLE, FOREIGN_TABLE, VIEW, MATERIALIZED_VIEW, SEQUENCE,
+}
+
+// Returns ctx.SearchPath, or DEFAULT_SEARCH_PATH when it isn't set.
+func getSearchPath(ctx *Context) []string {
+  if ctx == nil || len(ctx.SearchPath) == 0 {
+    return DEFAULT_SEARCH_PATH
+  }
+
+  return ctx.SearchPath
+}
+
+// Only postgres has schemas to qualify names with.
+func isSearchPathUsed(ctx *Context) bool {
+  return getDialect(ctx).Name() == DIALECT_POSTGRES
+}
+
+func isQualified(name string) bool {
+  return strings.Contains(name, ".")
+}
+
+// Qualifies the name of stmt with the schema it's created in, the first
+// schema on the search path.
+func qualifyStmtName(ctx *Context, stmt *ParsedStmt) {
+  stmt.WrittenName = stmt.Name
+
+  if !isSearchPathUsed(ctx) || !stmt.HasName || isQualified(stmt.Name) || !slices.Contains(SCHEMA_QUALIFIED_STMT_TYPES, stmt.StmtType) {
+    return
+  }
+
+  stmt.Name = getSearchPath(ctx)[0] + "." + stmt.Name
+}
+
+// Qualifies the unqualified names the statements depend on with the first
+// schema on the search path that has a statement of that name, or with the
+// first schema when none has. Run after qualifyStmtName on every statement.
+func qualifyDependencyNames(ctx *Context, stmts []*ParsedStmt) {
+  if !isSearchPathUsed(ctx) {
+    return
+  }
+
+  search_path := getSearchPath(ctx)
+  names := make(map[string]bool)
+
+  for _, stmt := range stmts {
+    names[stmt.Name] = true
+  }
+
+  for _, stmt := range stmts {
+    for _, dep := range stmt.Dependencies {
+      if isQualified(dep.StmtName) || !slices.Contains(SCHEMA_QUALIFIED_STMT_TYPES, dep.StmtType) {
+        continue
+      }
+
+      qualified := search_path[0] + "." + dep.StmtName
+
+      for _, schema := range search_path {
+        if names[schema + "." + dep.StmtName] {
+          qualified = schema + "." + dep.StmtName
+          break
+        }
+      }
+
+      dep.StmtName = qualified
+    }
+  }
+}
+
+// Statement types that were named by the last part of their name only, so
+// app.foo was stored as foo.
+var LAST_PART_NAMED_STMT_TYPES = []StmtType{ FUNCTION, DOMAIN, ENUM }
+
+// The name stmt was stored under before names were qualified, or "" when it's
+// the same as stmt.Name.
+func getUnqualifiedStoredName(ctx *Context, stmt *ParsedStmt) string {
+  if !stmt.HasName || stmt.WrittenName == "" {
+    return ""
+  }
+
+  name := stmt.WrittenName
+
+  if isSearchPathUsed(ctx) && slices.Contains(LAST_PART_NAMED_STMT_TYPES, stmt.StmtType) {
+    name = name[strings.LastIndex(name, ".") + 1:]
+  }
+
+  if name == stmt.Name {
+    return ""
+  }
+
+  return name
+}
+
+// Renames the statement stored for stmt before names were qualified to
+// stmt.Name, so that it's compared with stmt instead of being reported as
+// removed. Only the row with stmt's hash is renamed when there is one.
+// Returns whether a statement was renamed.
+func renameUnqualifiedStoredStmt(ctx *Context, stmt *ParsedStmt, hash_found bool) (bool, error) {
+  old_name := getUnqualifiedStoredName(ctx, stmt)
+
+  if old_name == "" || ctx.bookkeepingMissing {
+    return false, nil
+  }
+
+  query := "update {statements} set stmt_name=$1 where stmt_name=$2 and stmt_type=$3"
+  args := []any{ stmt.Name, old_name, stmt.StmtType }
+
+  if hash_found {
+    query += " and stmt_hash=$4"
+    args = append(args, stmt.Hash)
+  }
+
+  result, err := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, query), args...)
+
+  if err != nil {
+    return false, fmt.Errorf("renaming stored statement %s: %w", old_name, err)
+  }
+
+  renamed, err := result.RowsAffected()
+
+  return renamed > 0, err
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A postgres stand-in that records what is executed and returns no rows.
+type recordingConn struct {
+  executed *[]string
+}
+
+func (c recordingConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c recordingConn) Driver() driver.Driver { return nil }
+func (c recordingConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c recordingConn) Close() error { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c recordingConn) Commit() error { return nil }
+func (c recordingConn) Rollback() error { return nil }
+
+func (c recordingConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+  *c.executed = append(*c.executed, query)
+  return driver.RowsAffected(0), nil
+}
+
+func (c recordingConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+  return recordingRows{}, nil
+}
+
+type recordingRows struct {}
+
+func (recordingRows) Columns() []string { return []string{ "value" } }
+func (recordingRows) Close() error { return nil }
+func (recordingRows) Next([]driver.Value) error { return io.EOF }
+
+func TestSearchPathNames(t *testing.T) {
+  resolve := func(ctx *Context, code string) []*ParsedStmt {
+    stmts, e := getDialect(ctx).ParseStmts(code)
+    perr(e)
+
+    for _, stmt := range stmts {
+      qualifyStmtName(ctx, stmt)
+    }
+
+    qualifyDependencyNames(ctx, stmts)
+    hydrateDependencies(stmts)
+    return stmts
+  }
+
+  dependencyNames := func(stmt *ParsedStmt) []string {
+    var names []string
+
+    for _, dep := range stmt.Dependencies {
+      names = append(names, dep.StmtName)
+    }
+
+    return names
+  }
+
+  t.Run("qualified and unqualified names are the same object", func(t *testing.T) {
+    stmts := resolve(&Context{}, `
+      CREATE TABLE public.person (id int PRIMARY KEY);
+      CREATE TABLE pet (id int PRIMARY KEY, owner_id int REFERENCES person(id));
+      CREATE FUNCTION pet_count() RETURNS bigint AS 'SELECT count(*) FROM public.pet' LANGUAGE sql;
+    `)
+
+    var names []string
+
+    for _, stmt := range stmts {
+      names = append(names, stmt.Name)
+    }
+
+    if correct := []string{ "public.person", "public.pet", "public.pet_count" }; !reflect.DeepEqual(names, correct) {
+      test_failed(t, names, correct)
+    }
+
+    if correct := []string{ "public.person" }; !reflect.DeepEqual(dependencyNames(stmts[1]), correct) {
+      test_failed(t, dependencyNames(stmts[1]), correct)
+    }
+
+    if correct := []string{ "public.pet" }; !reflect.DeepEqual(dependencyNames(stmts[2]), correct) {
+      test_failed(t, dependencyNames(stmts[2]), correct)
+    }
+  })
+
+  t.Run("configured search path", func(t *testing.T) {
+    stmts := resolve(&Context{ SearchPath: []string{ "app", "public" } }, `
+      CREATE TABLE public.audit_log (id int PRIMARY KEY);
+      CREATE TABLE person (id int PRIMARY KEY);
+      CREATE TABLE report (log_id int REFERENCES audit_log, person_id int REFERENCES person);
+    `)
+
+    if stmts[2].Name != "app.report" {
+      test_failed(t, stmts[2].Name, "app.report")
+    }
+
+    if correct := []string{ "public.audit_log", "app.person" }; !reflect.DeepEqual(dependencyNames(stmts[2]), correct) {
+      test_failed(t, dependencyNames(stmts[2]), correct)
+    }
+  })
+
+  t.Run("names stored before qualifying", func(t *testing.T) {
+    stmts := resolve(&Context{}, `
+      CREATE TABLE person (id int PRIMARY KEY);
+      CREATE TABLE app.pet (id int PRIMARY KEY);
+      CREATE FUNCTION app.pet_count() RETURNS bigint AS 'SELECT 1' LANGUAGE sql;
+      CREATE DOMAIN age AS int;
+    `)
+
+    var names []string
+
+    for _, stmt := range stmts {
+      names = append(names, getUnqualifiedStoredName(&Context{}, stmt))
+    }
+
+    if correct := []string{ "person", "", "pet_count", "age" }; !reflect.DeepEqual(names, correct) {
+      test_failed(t, names, correct)
+    }
+  })
+
+  t.Run("dialects without schemas", func(t *testing.T) {
+    stmts := resolve(&Context{ Dialect: sqliteDialect{} }, "CREATE TABLE person (id integer primary key);")
+
+    if stmts[0].Name != "person" {
+      test_failed(t, stmts[0].Name, "person")
+    }
+  })
+}
+
+func TestSearchPathSet(t *testing.T) {
+  t.Run("objects created in the first schema", func(t *testing.T) {
+    dir := t.TempDir()
+    perr(os.WriteFile(filepath.Join(dir, "0000.sql"), []byte("CREATE TABLE person (id int);"), 0644))
+
+    var executed []string
+
+    db := sql.OpenDB(recordingConn{ &executed })
+    defer db.Close()
+
+    tx, e := db.Begin()
+    perr(e)
+
+    ctx := &Context{ Db: db, DbTx: tx, MigrationPath: dir, SearchPath: []string{ "app", "public" }, Action: MIGRATE }
+    perr(Initialize(ctx))
+
+    _, e = Migrate(ctx)
+    perr(e)
+
+    set := slices.Index(executed, `set local search_path to "app", "public"`)
+    create := slices.IndexFunc(executed, func(query string) bool { return strings.HasPrefix(query, "CREATE TABLE person") })
+
+    if set < 0 || create < 0 || set > create {
+      test_failed(t, executed, "search_path set to app, public before CREATE TABLE person")
+    }
+  })
+}
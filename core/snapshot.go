@@ -0,0 +1,94 @@
+package core
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+const SNAPSHOT_HEADER = "-- Generated by schemaflow. Do not edit by hand."
+
+type snapshotEntry struct {
+  stmtType int
+  stmtName string
+  deparsed string
+}
+
+// Every object in the database, read from the catalog so that migrations
+// written by hand are included too. Statements are deparsed when the dialect
+// can parse them, and kept as they are otherwise.
+func getSnapshotEntries(ctx *Context) ([]snapshotEntry, error) {
+  var entries []snapshotEntry
+
+  stmts, err := getDialect(ctx).CatalogStmts(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, s := range stmts {
+    parsed, err := getDialect(ctx).ParseStmts(s)
+
+    if err != nil {
+      entries = append(entries, snapshotEntry { int(UNKNOWN_TYPE), "", strings.TrimSpace(s) })
+      continue
+    }
+
+    for _, stmt := range parsed {
+      entries = append(entries, snapshotEntry { int(stmt.StmtType), stmt.Name, stmt.Deparsed })
+    }
+  }
+
+  sort.SliceStable(entries, func(i, j int) bool {
+    a, b := entries[i], entries[j]
+
+    if a.stmtType != b.stmtType {
+      return a.stmtType < b.stmtType
+    }
+
+    if a.stmtName != b.stmtName {
+      return a.stmtName < b.stmtName
+    }
+
+    return a.deparsed < b.deparsed
+  })
+
+  return entries, nil
+}
+
+func buildSnapshot(ctx *Context) (string, error) {
+  lines := []string{ SNAPSHOT_HEADER }
+
+  entries, err := getSnapshotEntries(ctx)
+
+  if err != nil {
+    return "", err
+  }
+
+  for _, entry := range entries {
+    lines = append(lines, entry.deparsed)
+  }
+
+  return strings.Join(lines, "\n") + "\n", nil
+}
+
+func writeSnapshot(ctx *Context) error {
+  if ctx.SnapshotPath == "" {
+    return nil
+  }
+
+  snapshot, err := buildSnapshot(ctx)
+
+  if err != nil {
+    return err
+  }
+
+  if err := os.WriteFile(ctx.SnapshotPath, []byte(snapshot), 0644); err != nil {
+    return err
+  }
+
+  getLogger(ctx).Info("Schema snapshot written", "file", ctx.SnapshotPath)
+  emit(ctx, Event { Type: EVENT_SNAPSHOT_WRITTEN, File: ctx.SnapshotPath })
+
+  return nil
+}
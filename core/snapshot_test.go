@@ -0,0 +1,116 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The snapshot is read from the database, so it holds the net effect of the
+// migrations, including ones written by hand.
+func TestSnapshotAfterMigrate(t *testing.T) {
+  t.Run("snapshot after migrate", func(t *testing.T) {
+    dir := t.TempDir()
+    migration_path := filepath.Join(dir, "migrations")
+    perr(os.MkdirAll(migration_path, 0755))
+
+    perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte(`
+      CREATE TABLE person (id integer primary key, name text);
+      CREATE TABLE old_person (id integer primary key);
+      CREATE INDEX person_name_idx ON person (name);
+      CREATE TABLE sqlite1_cache (id integer primary key);
+    `), 0644))
+
+    perr(os.WriteFile(filepath.Join(migration_path, "0001.sql"), []byte(`
+      -- schemaflow:allow-destructive
+      ALTER TABLE person ADD COLUMN age integer;
+      DROP TABLE old_person;
+      CREATE VIEW adult AS SELECT name FROM person WHERE age >= 18;
+    `), 0644))
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgDbName: filepath.Join(dir, "test.db") },
+      Dialect: sqliteDialect{},
+      MigrationPath: migration_path,
+      SnapshotPath: filepath.Join(dir, "schema.snapshot.sql"),
+      Action: MIGRATE,
+    }
+
+    _, e := migrateTarget(ctx)
+    perr(e)
+
+    snapshot, e := os.ReadFile(ctx.SnapshotPath)
+    perr(e)
+
+    correct := SNAPSHOT_HEADER + `
+CREATE TABLE person (id integer primary key, name text, age integer);
+CREATE TABLE sqlite1_cache (id integer primary key);
+CREATE VIEW adult AS SELECT name FROM person WHERE age >= 18;
+CREATE INDEX person_name_idx ON person (name);
+`
+
+    if string(snapshot) != correct {
+      test_failed(t, string(snapshot), correct)
+    }
+  })
+}
+
+// Projects sharing a database each have their own bookkeeping tables, none
+// of which are part of a snapshot.
+func TestSnapshotSkipsOtherBookkeeping(t *testing.T) {
+  t.Run("snapshot skips other bookkeeping", func(t *testing.T) {
+    dir := t.TempDir()
+    db_path := filepath.Join(dir, "test.db")
+
+    for _, prefix := range []string{ "other_", "" } {
+      migration_path := filepath.Join(dir, "migrations" + prefix)
+      perr(os.MkdirAll(migration_path, 0755))
+
+      perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte(`
+        CREATE TABLE ` + prefix + `person (id integer primary key);
+      `), 0644))
+
+      ctx := &Context{
+        DbContext: &DbContext{ PgDbName: db_path },
+        Dialect: sqliteDialect{},
+        MigrationPath: migration_path,
+        SnapshotPath: filepath.Join(dir, prefix + "schema.snapshot.sql"),
+        TablePrefix: prefix,
+        Action: MIGRATE,
+      }
+
+      _, e := migrateTarget(ctx)
+      perr(e)
+    }
+
+    snapshot, e := os.ReadFile(filepath.Join(dir, "schema.snapshot.sql"))
+    perr(e)
+
+    correct := SNAPSHOT_HEADER + `
+CREATE TABLE other_person (id integer primary key);
+CREATE TABLE person (id integer primary key);
+`
+
+    if string(snapshot) != correct {
+      test_failed(t, string(snapshot), correct)
+    }
+  })
+}
+
+// No postgres server is at hand in the tests, so this only checks that
+// the catalog queries are valid SQL.
+func TestPostgresCatalogQueries(t *testing.T) {
+  t.Run("postgres catalog queries", func(t *testing.T) {
+    for _, query := range POSTGRES_CATALOG_QUERIES {
+      parsed, e := parseSql(query)
+
+      if e != nil {
+        t.Fatalf("%s\n%v", query, e)
+      }
+
+      if len(parsed.Stmts) != 1 {
+        test_failed(t, len(parsed.Stmts), 1)
+      }
+    }
+  })
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"strings"
+
 	pg_query "github.com/pganalyze/pg_query_go/v5"
 )
 
@@ -19,3 +21,40 @@ func parseSql(code string) (*pg_query.ParseResult, error) {
   pr, err := pg_query.Parse(code) 
   return pr, err
 }
+
+// The source text of a statement, including any comments that precede it.
+func rawStmtSource(code string, x *pg_query.RawStmt) string {
+  start := int(x.GetStmtLocation())
+  end := len(code)
+
+  if x.GetStmtLen() > 0 {
+    end = start + int(x.GetStmtLen())
+  }
+
+  if start > len(code) {
+    return ""
+  }
+
+  if end > len(code) {
+    end = len(code)
+  }
+
+  return code[start:end]
+}
+
+// The line the statement itself starts on, skipping blank lines and comments.
+func rawStmtLine(code string, x *pg_query.RawStmt) int {
+  line := strings.Count(code[:min(int(x.GetStmtLocation()), len(code))], "\n") + 1
+
+  for _, l := range strings.Split(rawStmtSource(code, x), "\n") {
+    trimmed := strings.TrimSpace(l)
+
+    if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+      break
+    }
+
+    line++
+  }
+
+  return line
+}
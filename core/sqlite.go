@@ -0,0 +1,588 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite has no schemas, so the bookkeeping tables are prefixed with the
+// bookkeeping schema instead.
+const SQLITE_MIGRATION_SCHEMA = `
+create table if not exists {migrations} (
+  file_name text primary key not null,
+  file_hash text not null,
+  created timestamp default current_timestamp
+);
+
+create table if not exists {statements} (
+  id integer primary key autoincrement,
+  stmt text not null,
+  stmt_hash text unique not null,
+  stmt_type integer not null,
+  stmt_name text default null,
+  created timestamp default current_timestamp,
+  updated timestamp default current_timestamp
+);
+`
+
+type sqliteDialect struct {}
+
+func (sqliteDialect) Name() string {
+  return DIALECT_SQLITE
+}
+
+// --db is the path of the database file.
+func (sqliteDialect) Open(ctx context.Context, db_ctx *DbContext) (*sql.DB, error) {
+  if db_ctx.PgDbName == "" {
+    return nil, fmt.Errorf("'db' is required for %s", DIALECT_SQLITE)
+  }
+
+  return openDb(ctx, "sqlite3", db_ctx.PgDbName)
+}
+
+func (sqliteDialect) MigrationSchema() string {
+  return SQLITE_MIGRATION_SCHEMA
+}
+
+func (sqliteDialect) Table(schema string, name string) string {
+  return schema + "_" + name
+}
+
+func (sqliteDialect) CatalogStmts(ctx *Context) ([]string, error) {
+  // Indexes SQLite makes for constraints have no sql. SQLite's own tables
+  // start with sqlite_, where LIKE would take the _ as a wildcard. Other
+  // projects' bookkeeping tables are told apart by their columns.
+  query := `select sql from sqlite_master m where sql is not null and substr(name, 1, 7) <> 'sqlite_' and tbl_name not in ($1, $2)
+    and coalesce((select group_concat(name, ',' order by name) from pragma_table_info(m.tbl_name)), '') not in (` + bookkeepingColumnsSql() + `)`
+
+  stmts, err := queryStrings(ctx, bookkeepingSql(ctx, query), bookkeepingSql(ctx, "{migrations}"), bookkeepingSql(ctx, "{statements}"))
+
+  if err != nil {
+    return nil, fmt.Errorf("reading the catalog: %w", err)
+  }
+
+  for i, stmt := range stmts {
+    stmts[i] = stmt + ";"
+  }
+
+  return stmts, nil
+}
+
+// SQLite numbers $1 style parameters by first appearance, ?1 by its number.
+func (sqliteDialect) Rebind(query string) string {
+  return postgresPlaceholders.ReplaceAllStringFunc(query, func(p string) string {
+    return "?" + p[1:]
+  })
+}
+
+type sqliteTokenKind int
+
+const (
+  SQLITE_WORD sqliteTokenKind = iota
+  SQLITE_IDENT
+  SQLITE_STRING
+  SQLITE_PUNCT
+)
+
+type sqliteToken struct {
+  kind sqliteTokenKind
+  text string
+  offset int
+}
+
+// Bare words are compared case insensitively.
+func (t sqliteToken) is(words ...string) bool {
+  if t.kind != SQLITE_WORD {
+    return false
+  }
+
+  for _, word := range words {
+    if strings.EqualFold(t.text, word) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// The identifier the token names, without quotes and lower cased, as SQLite
+// compares identifiers case insensitively.
+func (t sqliteToken) name() string {
+  if t.kind == SQLITE_IDENT {
+    return strings.ToLower(t.text[1:len(t.text) - 1])
+  }
+
+  return strings.ToLower(t.text)
+}
+
+func isSqliteWordChar(c byte) bool {
+  return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// Operators longer than one character, kept as one token so that they're
+// deparsed without a space inside them. Longest first.
+var SQLITE_OPERATORS = []string{ "->>", "->", "<=", ">=", "<>", "!=", "==", "||", "<<", ">>" }
+
+// Splits code into tokens, dropping whitespace and comments.
+func tokenizeSqlite(code string) ([]sqliteToken, error) {
+  var tokens []sqliteToken
+
+  for i := 0; i < len(code); {
+    c := code[i]
+
+    switch {
+      case c == ' ' || c == '\t' || c == '\n' || c == '\r': {
+        i++
+      }
+
+      case strings.HasPrefix(code[i:], "--"): {
+        end := strings.IndexByte(code[i:], '\n')
+
+        if end < 0 {
+          end = len(code) - i
+        }
+
+        i += end
+      }
+
+      case strings.HasPrefix(code[i:], "/*"): {
+        end := strings.Index(code[i + 2:], "*/")
+
+        if end < 0 {
+          return nil, fmt.Errorf("unterminated comment at line %d", lineOfOffset(code, i))
+        }
+
+        i += end + 4
+      }
+
+      case c == '\'' || c == '"' || c == '`' || c == '[': {
+        closing := c
+
+        if c == '[' {
+          closing = ']'
+        }
+
+        end := i + 1
+
+        for ; end < len(code); end++ {
+          if code[end] != closing {
+            continue
+          }
+
+          // Quotes are escaped by doubling them.
+          if closing != ']' && end + 1 < len(code) && code[end + 1] == closing {
+            end++
+            continue
+          }
+
+          break
+        }
+
+        if end >= len(code) {
+          return nil, fmt.Errorf("unterminated %c at line %d", c, lineOfOffset(code, i))
+        }
+
+        kind := SQLITE_IDENT
+
+        if c == '\'' {
+          kind = SQLITE_STRING
+        }
+
+        tokens = append(tokens, sqliteToken { kind, code[i:end + 1], i })
+        i = end + 1
+      }
+
+      case isSqliteWordChar(c): {
+        end := i
+
+        for end < len(code) && isSqliteWordChar(code[end]) {
+          end++
+        }
+
+        tokens = append(tokens, sqliteToken { SQLITE_WORD, code[i:end], i })
+        i = end
+      }
+
+      default: {
+        size := 1
+
+        for _, op := range SQLITE_OPERATORS {
+          if strings.HasPrefix(code[i:], op) {
+            size = len(op)
+            break
+          }
+        }
+
+        tokens = append(tokens, sqliteToken { SQLITE_PUNCT, code[i:i + size], i })
+        i += size
+      }
+    }
+  }
+
+  return tokens, nil
+}
+
+// Splits tokens into statements on semicolons. Trigger bodies hold
+// semicolons of their own between BEGIN and END.
+func splitSqliteStmts(tokens []sqliteToken) [][]sqliteToken {
+  var stmts [][]sqliteToken
+  var current []sqliteToken
+
+  depth := 0
+
+  for _, t := range tokens {
+    if t.kind == SQLITE_PUNCT && t.text == ";" && depth == 0 {
+      if len(current) > 0 {
+        stmts = append(stmts, current)
+      }
+
+      current = nil
+      continue
+    }
+
+    current = append(current, t)
+
+    if isSqliteTrigger(current) {
+      if t.is("BEGIN", "CASE") {
+        depth++
+      } else if t.is("END") && depth > 0 {
+        depth--
+      }
+    }
+  }
+
+  if len(current) > 0 {
+    stmts = append(stmts, current)
+  }
+
+  return stmts
+}
+
+func isSqliteTrigger(tokens []sqliteToken) bool {
+  i := skipSqliteWords(tokens, 0, "CREATE", "TEMP", "TEMPORARY")
+  return i > 0 && i < len(tokens) && tokens[i].is("TRIGGER")
+}
+
+// The statement with single spaces between tokens, so that whitespace and
+// comments don't count as changes.
+func deparseSqliteStmt(tokens []sqliteToken) string {
+  var sb strings.Builder
+
+  for i, t := range tokens {
+    if i > 0 && !(t.kind == SQLITE_PUNCT && strings.Contains(",.)", t.text)) && !(tokens[i - 1].kind == SQLITE_PUNCT && strings.Contains("(.", tokens[i - 1].text)) {
+      sb.WriteByte(' ')
+    }
+
+    sb.WriteString(t.text)
+  }
+
+  return sb.String() + ";"
+}
+
+// Reads a possibly schema qualified name starting at tokens[i] and returns it
+// with the index of the token after it.
+func readSqliteName(tokens []sqliteToken, i int) (string, int) {
+  if i >= len(tokens) || tokens[i].kind != SQLITE_WORD && tokens[i].kind != SQLITE_IDENT {
+    return "", i
+  }
+
+  name := tokens[i].name()
+  i++
+
+  if i + 1 < len(tokens) && tokens[i].text == "." {
+    name = name + "." + tokens[i + 1].name()
+    i += 2
+  }
+
+  return name, i
+}
+
+// Skips the optional words in e.g. CREATE TEMP TABLE IF NOT EXISTS.
+func skipSqliteWords(tokens []sqliteToken, i int, words ...string) int {
+  for i < len(tokens) && tokens[i].is(words...) {
+    i++
+  }
+
+  return i
+}
+
+// Words that end a table list rather than being an alias.
+var SQLITE_CLAUSE_WORDS = []string{ "WHERE", "GROUP", "ORDER", "LIMIT", "HAVING", "WINDOW", "UNION", "EXCEPT", "INTERSECT", "ON", "USING", "JOIN", "LEFT", "RIGHT", "FULL", "INNER", "OUTER", "CROSS", "NATURAL", "SET", "VALUES", "SELECT", "DEFAULT", "RETURNING", "BEGIN", "END", "WHEN", "FOR", "AS", "OF" }
+
+// Every table named after FROM, JOIN, INTO, UPDATE or REFERENCES.
+func getSqliteTableRefs(tokens []sqliteToken) []string {
+  var tables []string
+
+  for i := 0; i < len(tokens); i++ {
+    if !tokens[i].is("FROM", "JOIN", "INTO", "UPDATE", "REFERENCES") {
+      continue
+    }
+
+    for j := skipSqliteWords(tokens, i + 1, "OR", "ROLLBACK", "ABORT", "REPLACE", "FAIL", "IGNORE"); j < len(tokens); {
+      if tokens[j].is(SQLITE_CLAUSE_WORDS...) {
+        break
+      }
+
+      name, next := readSqliteName(tokens, j)
+
+      if name == "" {
+        break
+      }
+
+      tables = append(tables, name)
+      j = skipSqliteWords(tokens, next, "AS")
+
+      // An alias.
+      if j < len(tokens) && (tokens[j].kind == SQLITE_IDENT || tokens[j].kind == SQLITE_WORD && !tokens[j].is(SQLITE_CLAUSE_WORDS...)) {
+        j++
+      }
+
+      if j >= len(tokens) || tokens[j].text != "," || !tokens[i].is("FROM") {
+        break
+      }
+
+      j++
+    }
+  }
+
+  return tables
+}
+
+var SQLITE_DROPPED_TYPES = map[string]StmtType{ "TABLE": TABLE, "VIEW": VIEW, "INDEX": INDEX, "TRIGGER": TRIGGER }
+
+func hydrateSqliteStmt(tokens []sqliteToken, ps *ParsedStmt) {
+  i := skipSqliteWords(tokens, 0, "CREATE", "TEMP", "TEMPORARY", "UNIQUE", "VIRTUAL")
+  first := tokens[0]
+
+  if first.is("CREATE") && i < len(tokens) {
+    kind := tokens[i]
+    ps.Name, i = readSqliteName(tokens, skipSqliteWords(tokens, i + 1, "IF", "NOT", "EXISTS"))
+
+    switch {
+      case kind.is("TABLE"): {
+        ps.StmtType = TABLE
+      }
+
+      case kind.is("VIEW"): {
+        ps.StmtType = VIEW
+      }
+
+      case kind.is("INDEX"): {
+        ps.StmtType = INDEX
+      }
+
+      case kind.is("TRIGGER"): {
+        ps.StmtType = TRIGGER
+      }
+
+      default: {
+        ps.Name = ""
+        ps.StmtType = UNKNOWN_TYPE
+      }
+    }
+
+    // Indexes and triggers depend on the table they are ON.
+    for ; i < len(tokens) && (ps.StmtType == INDEX || ps.StmtType == TRIGGER); i++ {
+      if tokens[i].is("ON") {
+        table, _ := readSqliteName(tokens, i + 1)
+        appendDependency(ps, TABLE, table)
+        break
+      }
+    }
+  } else {
+    switch {
+      case first.is("ALTER"): {
+        ps.StmtType = ALTER_TABLE
+
+        if len(tokens) > 2 {
+          table, _ := readSqliteName(tokens, 2)
+          appendDependency(ps, TABLE, table)
+        }
+      }
+
+      case first.is("INSERT", "REPLACE"): {
+        ps.StmtType = INSERT
+      }
+
+      case first.is("UPDATE"): {
+        ps.StmtType = UPDATE
+      }
+
+      case first.is("SELECT", "WITH"): {
+        ps.StmtType = SELECT
+      }
+
+      // Drops depend on what they drop, as in postgres.
+      case first.is("DROP"): {
+        ps.StmtType = DROP
+
+        if len(tokens) > 2 {
+          kind, ok := SQLITE_DROPPED_TYPES[strings.ToUpper(tokens[1].text)]
+          name, _ := readSqliteName(tokens, skipSqliteWords(tokens, 2, "IF", "EXISTS"))
+
+          if ok && name != "" {
+            appendDependency(ps, kind, name)
+          }
+        }
+      }
+
+      default: {
+        ps.StmtType = UNKNOWN_TYPE
+      }
+    }
+  }
+
+  for _, table := range getSqliteTableRefs(tokens) {
+    if table != ps.Name {
+      appendDependency(ps, TABLE, table)
+    }
+  }
+
+  ps.HasName = ps.Name != ""
+}
+
+// Errors sqlite3_prepare reports for a statement it can't parse, as opposed
+// to ones like "no such table" about the empty database it's prepared in.
+var SQLITE_SYNTAX_ERRORS = []string{ "syntax error", "incomplete input", "unrecognized token" }
+
+// Has SQLite prepare stmt in db, an empty in-memory database, so that syntax
+// errors are reported before the migration runs.
+func checkSqliteSyntax(db *sql.DB, code string, stmt []sqliteToken) error {
+  last := stmt[len(stmt) - 1]
+  prepared, err := db.Prepare(code[stmt[0].offset:last.offset + len(last.text)])
+
+  if err == nil {
+    return prepared.Close()
+  }
+
+  for _, syntax_error := range SQLITE_SYNTAX_ERRORS {
+    if strings.Contains(err.Error(), syntax_error) {
+      return fmt.Errorf("%v at line %d", err, lineOfOffset(code, stmt[0].offset))
+    }
+  }
+
+  return nil
+}
+
+func (sqliteDialect) ParseStmts(code string) ([]*ParsedStmt, error) {
+  var ps []*ParsedStmt
+
+  tokens, err := tokenizeSqlite(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  db, err := sql.Open("sqlite3", ":memory:")
+
+  if err != nil {
+    return nil, err
+  }
+
+  defer db.Close()
+
+  for _, stmt := range splitSqliteStmts(tokens) {
+    if err := checkSqliteSyntax(db, code, stmt); err != nil {
+      return nil, err
+    }
+
+    dp := deparseSqliteStmt(stmt)
+
+    nps := &ParsedStmt{
+      Deparsed: dp,
+      Hash: HashString(dp),
+      StmtType: UNKNOWN_TYPE,
+      Dependencies: make([]*Dependency, 0),
+      Status: UNKNOWN,
+    }
+
+    hydrateSqliteStmt(stmt, nps)
+
+    ps = append(ps, nps)
+  }
+
+  return ps, nil
+}
+
+// Skips a leading WITH clause so the statement it feeds can be checked.
+func skipSqliteWith(tokens []sqliteToken) []sqliteToken {
+  if !tokens[0].is("WITH") {
+    return tokens
+  }
+
+  depth := 0
+
+  for i, t := range tokens {
+    switch {
+      case t.kind == SQLITE_PUNCT && t.text == "(": {
+        depth++
+      }
+
+      case t.kind == SQLITE_PUNCT && t.text == ")": {
+        depth--
+      }
+
+      case depth == 0 && t.is("SELECT", "VALUES", "INSERT", "REPLACE", "UPDATE", "DELETE"): {
+        return tokens[i:]
+      }
+    }
+  }
+
+  return tokens
+}
+
+func getSqliteDestructiveReason(tokens []sqliteToken) string {
+  tokens = skipSqliteWith(tokens)
+  first := tokens[0]
+
+  switch {
+    case first.is("DROP"): {
+      return "DROP"
+    }
+
+    case first.is("ALTER"): {
+      // ALTER TABLE name DROP [COLUMN] column, the only ALTER that loses data.
+      if len(tokens) > 1 && tokens[1].is("TABLE") {
+        _, i := readSqliteName(tokens, 2)
+
+        if i < len(tokens) && tokens[i].is("DROP") {
+          return "ALTER TABLE ... DROP COLUMN"
+        }
+      }
+    }
+
+    case first.is("DELETE"): {
+      for _, t := range tokens {
+        if t.is("WHERE") {
+          return ""
+        }
+      }
+
+      return "DELETE without a WHERE clause"
+    }
+  }
+
+  return ""
+}
+
+func (sqliteDialect) DestructiveStmts(file string, code string) ([]string, error) {
+  var destructive []string
+
+  tokens, err := tokenizeSqlite(code)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, stmt := range splitSqliteStmts(tokens) {
+    if reason := getSqliteDestructiveReason(stmt); reason != "" {
+      destructive = append(destructive, fmt.Sprintf("%s:%d %s", file, lineOfOffset(code, stmt[0].offset), reason))
+    }
+  }
+
+  return destructive, nil
+}
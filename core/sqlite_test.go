@@ -0,0 +1,308 @@
+package core
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSqliteStmts(t *testing.T) {
+  schema := `
+    CREATE INDEX person_address_person_idx ON person_address (person_id);
+
+    -- Addresses are removed with their person.
+    CREATE TABLE "person_address" (
+      id integer primary key,
+      person_id integer REFERENCES person(id) ON DELETE CASCADE,
+      street text default 'Main; St'
+    );
+
+    CREATE VIEW person_with_address AS
+      SELECT p.name FROM person p JOIN person_address AS a ON a.person_id = p.id;
+
+    CREATE TRIGGER person_renamed AFTER UPDATE OF name ON person
+    BEGIN
+      UPDATE person_address SET street = CASE WHEN new.name = '' THEN street ELSE street END WHERE person_id = new.id;
+    END;
+
+    CREATE TABLE person (id integer primary key, name text);
+  `
+
+  t.Run("sqlite statements", func(t *testing.T) {
+    stmts, e := sqliteDialect{}.ParseStmts(schema)
+    perr(e)
+    hydrateDependencies(stmts)
+
+    var order []string
+
+    for _, stmt := range sortStmtsByPriority(stmts) {
+      order = append(order, stmt.Name)
+    }
+
+    correct := []string{ "person", "person_address", "person_address_person_idx", "person_with_address", "person_renamed" }
+
+    if !reflect.DeepEqual(order, correct) {
+      test_failed(t, order, correct)
+    }
+
+    types := []StmtType{ stmts[0].StmtType, stmts[1].StmtType, stmts[2].StmtType, stmts[3].StmtType }
+
+    if !reflect.DeepEqual(types, []StmtType{ INDEX, TABLE, VIEW, TRIGGER }) {
+      test_failed(t, types, []StmtType{ INDEX, TABLE, VIEW, TRIGGER })
+    }
+
+    deparsed := "CREATE TABLE person (id integer primary key, name text);"
+
+    if stmts[4].Deparsed != deparsed {
+      test_failed(t, stmts[4].Deparsed, deparsed)
+    }
+  })
+
+  t.Run("sqlite operators", func(t *testing.T) {
+    parsed, e := (sqliteDialect{}).ParseStmts("CREATE VIEW adult AS SELECT name || '!' FROM person WHERE age>=18 AND age <> 99;")
+    perr(e)
+
+    correct := "CREATE VIEW adult AS SELECT name || '!' FROM person WHERE age >= 18 AND age <> 99;"
+
+    if parsed[0].Deparsed != correct {
+      test_failed(t, parsed[0].Deparsed, correct)
+    }
+  })
+
+  t.Run("sqlite syntax error", func(t *testing.T) {
+    _, e := (sqliteDialect{}).ParseStmts("CREATE TABLE a (b text);\nCREATE TABEL c (d text);")
+
+    if e == nil || !strings.Contains(e.Error(), "syntax error") || !strings.Contains(e.Error(), "line 2") {
+      test_failed(t, e, "a syntax error at line 2")
+    }
+  })
+
+  t.Run("sqlite missing table", func(t *testing.T) {
+    // Tables come from other files, so only syntax is checked.
+    _, e := (sqliteDialect{}).ParseStmts("CREATE INDEX a_b_idx ON a (b); CREATE VIEW c AS SELECT b FROM a;")
+    perr(e)
+  })
+
+  t.Run("sqlite unterminated string", func(t *testing.T) {
+    if _, e := (sqliteDialect{}).ParseStmts("CREATE TABLE a (b text default 'c);"); e == nil {
+      t.Errorf("expected a syntax error")
+    }
+  })
+}
+
+func TestSqliteDropDependencies(t *testing.T) {
+  t.Run("sqlite drops", func(t *testing.T) {
+    stmts, e := (sqliteDialect{}).ParseStmts("DROP TABLE IF EXISTS main.person; DROP INDEX person_name_idx; DROP VIEW adult;")
+    perr(e)
+
+    var checked []Dependency
+
+    for _, stmt := range stmts {
+      for _, dep := range stmt.Dependencies {
+        checked = append(checked, *dep)
+      }
+    }
+
+    correct := []Dependency{
+      *buildDependency(TABLE, "main.person"),
+      *buildDependency(INDEX, "person_name_idx"),
+      *buildDependency(VIEW, "adult"),
+    }
+
+    if !reflect.DeepEqual(checked, correct) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+func TestSqliteDestructiveStmts(t *testing.T) {
+  migration := `
+    DROP TABLE old_person;
+    ALTER TABLE person DROP COLUMN name;
+    ALTER TABLE person ADD COLUMN age integer;
+    DELETE FROM person;
+    DELETE FROM person WHERE id = 1;
+    DROP INDEX person_age_idx;
+    ALTER TABLE main.person DROP age;
+    ALTER TABLE person RENAME COLUMN dropped TO removed;
+    WITH old AS (SELECT id FROM person WHERE id < 10) DELETE FROM person;
+    WITH RECURSIVE old(id) AS (SELECT 1) DELETE FROM person WHERE id IN old;
+  `
+
+  t.Run("sqlite destructive statements", func(t *testing.T) {
+    checked, e := sqliteDialect{}.DestructiveStmts("0001.sql", migration)
+    perr(e)
+
+    correct := []string{
+      "0001.sql:2 DROP",
+      "0001.sql:3 ALTER TABLE ... DROP COLUMN",
+      "0001.sql:5 DELETE without a WHERE clause",
+      "0001.sql:7 DROP",
+      "0001.sql:8 ALTER TABLE ... DROP COLUMN",
+      "0001.sql:10 DELETE without a WHERE clause",
+    }
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct)
+    }
+  })
+}
+
+// Runs make and migrate against a SQLite file, which needs no server.
+func TestSqliteMakeAndMigrate(t *testing.T) {
+  t.Run("sqlite make and migrate", func(t *testing.T) {
+    dir := t.TempDir()
+    sql_path := filepath.Join(dir, "schema")
+    perr(os.MkdirAll(sql_path, 0755))
+
+    perr(os.WriteFile(filepath.Join(sql_path, "person.sql"), []byte(`
+      CREATE TABLE pet (id integer primary key, person_id integer REFERENCES person(id));
+      CREATE TABLE person (id integer primary key, name text);
+    `), 0644))
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgDbName: filepath.Join(dir, "test.db") },
+      Dialect: sqliteDialect{},
+      SqlPath: sql_path,
+      MigrationPath: filepath.Join(dir, "migrations"),
+    }
+
+    db, e := CreateDbConnections(ctx)
+    perr(e)
+    defer db.Close()
+
+    ctx.Db = db
+
+    run := func(action ActionType, fn func(ctx *Context) error) {
+      tx, e := db.Begin()
+      perr(e)
+
+      ctx.DbTx = tx
+      ctx.Action = action
+
+      perr(Initialize(ctx))
+      perr(fn(ctx))
+      perr(tx.Commit())
+    }
+
+    var made *MakeResult
+
+    run(MAKEMIGRATIONS, func(ctx *Context) (err error) {
+      made, err = MakeMigrations(ctx)
+      return err
+    })
+
+    if made.Statements != 2 {
+      test_failed(t, made.Statements, 2)
+    }
+
+    var migrated *MigrateResult
+
+    run(MIGRATE, func(ctx *Context) (err error) {
+      migrated, err = Migrate(ctx)
+      return err
+    })
+
+    if !reflect.DeepEqual(migrated.Executed, []string{ "0000.sql" }) {
+      test_failed(t, migrated.Executed, []string{ "0000.sql" })
+    }
+
+    var tables []string
+
+    rows, e := db.Query("select name from sqlite_master where type = 'table' and name not like 'schemaflow_%' and name not like 'sqlite_%' order by name")
+    perr(e)
+    defer rows.Close()
+
+    for rows.Next() {
+      var name string
+      perr(rows.Scan(&name))
+      tables = append(tables, name)
+    }
+
+    if !reflect.DeepEqual(tables, []string{ "person", "pet" }) {
+      test_failed(t, tables, []string{ "person", "pet" })
+    }
+
+    run(MAKEMIGRATIONS, func(ctx *Context) (err error) {
+      made, err = MakeMigrations(ctx)
+      return err
+    })
+
+    if made.File != "" {
+      test_failed(t, made.File, "no migration")
+    }
+  })
+}
+
+// Two projects with their own bookkeeping tables in one database.
+func TestSqliteBookkeepingNames(t *testing.T) {
+  dir := t.TempDir()
+  db_path := filepath.Join(dir, "shared.db")
+
+  migrate := func(project string, schema string, prefix string) *MigrateResult {
+    migration_path := filepath.Join(dir, project)
+    perr(os.MkdirAll(migration_path, 0755))
+    perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte("CREATE TABLE " + project + " (id integer primary key);\n"), 0644))
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgDbName: db_path },
+      Dialect: sqliteDialect{},
+      MigrationPath: migration_path,
+      Action: MIGRATE,
+      BookkeepingSchema: schema,
+      TablePrefix: prefix,
+    }
+
+    result, e := migrateTarget(ctx)
+    perr(e)
+
+    return result
+  }
+
+  t.Run("independent projects", func(t *testing.T) {
+    for _, project := range []string{ "app", "platform" } {
+      schema, prefix := "schemaflow", ""
+
+      if project == "platform" {
+        schema, prefix = "platform", "sf_"
+      }
+
+      if result := migrate(project, schema, prefix); !reflect.DeepEqual(result.Executed, []string{ "0000.sql" }) {
+        test_failed(t, result.Executed, []string{ "0000.sql" })
+      }
+    }
+
+    db, e := sql.Open("sqlite3", db_path)
+    perr(e)
+    defer db.Close()
+
+    var tables []string
+
+    rows, e := db.Query("select name from sqlite_master where type = 'table' and name like '%migrations' order by name")
+    perr(e)
+    defer rows.Close()
+
+    for rows.Next() {
+      var name string
+      perr(rows.Scan(&name))
+      tables = append(tables, name)
+    }
+
+    correct := []string{ "platform_sf_migrations", "schemaflow_migrations" }
+
+    if !reflect.DeepEqual(tables, correct) {
+      test_failed(t, tables, correct)
+    }
+  })
+
+  t.Run("invalid names", func(t *testing.T) {
+    for _, ctx := range []*Context{ { BookkeepingSchema: "Platform" }, { TablePrefix: "sf; drop table x" } } {
+      if e := validateBookkeepingNames(ctx); e == nil {
+        t.Errorf("expected an error for %q %q", ctx.BookkeepingSchema, ctx.TablePrefix)
+      }
+    }
+  })
+}
@@ -0,0 +1,442 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func migrationNumber(file string) (int, bool) {
+  name := extractFileFromPath(file)
+
+  end := 0
+  for end < len(name) && name[end] >= '0' && name[end] <= '9' {
+    end++
+  }
+
+  if end == 0 {
+    return 0, false
+  }
+
+  number, err := strconv.Atoi(name[:end])
+
+  return number, err == nil
+}
+
+func getSquashedFiles(ctx *Context, file string) ([]string, error) {
+  if isGoMigration(ctx, file) {
+    return nil, nil
+  }
+
+  code, err := readMigrationFile(ctx, file)
+
+  return getDirectiveValues(code, DIRECTIVE_SQUASHED), err
+}
+
+// Maps every migration that was squashed away to the baseline that replaced it.
+func getSquashedMigrations(ctx *Context) (map[string]string, error) {
+  squashed := make(map[string]string)
+
+  files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, file := range files {
+    replaced, err := getSquashedFiles(ctx, file)
+
+    if err != nil {
+      return nil, err
+    }
+
+    for _, old := range replaced {
+      squashed[old] = extractFileFromPath(file)
+    }
+  }
+
+  return squashed, nil
+}
+
+func recordMigrationAsExecuted(ctx *Context, migrationFile string) error {
+  hash, err := hashMigrationFile(ctx, migrationFile)
+
+  if err != nil {
+    return err
+  }
+
+  return insertExecutedMigration(ctx, extractFileFromPath(migrationFile), hash)
+}
+
+// A baseline does not need to run on databases that already executed every
+// migration it replaced. It is recorded as executed instead.
+func isBaselineAlreadyApplied(ctx *Context, migrationFile string) (bool, error) {
+  squashed, err := getSquashedFiles(ctx, migrationFile)
+
+  if err != nil || len(squashed) == 0 {
+    return false, err
+  }
+
+  executed := make(map[string]bool)
+
+  executedMigrations, err := getListOfExecutedMigrationFiles(ctx)
+
+  if err != nil {
+    return false, err
+  }
+
+  for _, em := range executedMigrations {
+    executed[extractFileFromPath(em.fileName)] = true
+  }
+
+  var missing []string
+
+  for _, old := range squashed {
+    if !executed[old] {
+      missing = append(missing, old)
+    }
+  }
+
+  if len(missing) == 0 {
+    return true, nil
+  }
+
+  if len(missing) != len(squashed) {
+    return false, &PartialBaselineError { migrationFile, missing }
+  }
+
+  return false, nil
+}
+
+func buildBaseline(ctx *Context, files []string) (string, error) {
+  var lines []string
+  var codes []string
+
+  for _, file := range files {
+    code, err := readMigrationFile(ctx, file)
+
+    if err != nil {
+      return "", err
+    }
+
+    codes = append(codes, code)
+
+    replaced := append(getDirectiveValues(code, DIRECTIVE_SQUASHED), extractFileFromPath(file))
+
+    for _, old := range replaced {
+      lines = append(lines, fmt.Sprintf("%s%s %s", DIRECTIVE_PREFIX, DIRECTIVE_SQUASHED, old))
+    }
+  }
+
+  // A baseline runs as one role, so the migrations it replaces must share
+  // theirs.
+  roles := make(map[string][]string)
+
+  for i, code := range codes {
+    role := getMigrationRole(&Context{}, code)
+    roles[role] = append(roles[role], extractFileFromPath(files[i]))
+  }
+
+  if len(roles) > 1 {
+    var groups []string
+
+    for role, role_files := range roles {
+      if role == "" {
+        role = "no role"
+      }
+
+      groups = append(groups, fmt.Sprintf("%s (%s)", role, strings.Join(role_files, ", ")))
+    }
+
+    sort.Strings(groups)
+    return "", fmt.Errorf("the migrations being squashed run as different roles: %s. Squash through a migration before the role changes", strings.Join(groups, "; "))
+  }
+
+  for role := range roles {
+    if role != "" {
+      lines = append(lines, fmt.Sprintf("%s%s %s", DIRECTIVE_PREFIX, DIRECTIVE_ROLE, role))
+    }
+  }
+
+  var stmts []*ParsedStmt
+
+  for i, code := range codes {
+    parsed, err := getDialect(ctx).ParseStmts(code)
+
+    if err != nil {
+      return "", &SyntaxError { files[i], err }
+    }
+
+    for _, stmt := range parsed {
+      stmt.File = extractFileFromPath(files[i])
+    }
+
+    stmts = append(stmts, parsed...)
+  }
+
+  stmts = foldBaselineStmts(stmts)
+
+  // An allow-destructive directive in one of the files only allowed that
+  // file, so what is still destructive after folding can't be carried over.
+  var destructive []string
+
+  for _, stmt := range stmts {
+    found, err := getDialect(ctx).DestructiveStmts(stmt.File, stmt.Deparsed)
+
+    if err != nil {
+      return "", &SyntaxError { stmt.File, err }
+    }
+
+    if len(found) > 0 {
+      destructive = append(destructive, fmt.Sprintf("%s (%s)", stmt.Deparsed, stmt.File))
+    }
+  }
+
+  if len(destructive) > 0 {
+    return "", fmt.Errorf("the baseline would still drop or delete data: %s. Squash through a migration before them", strings.Join(destructive, "; "))
+  }
+
+  for _, stmt := range stmts {
+    lines = append(lines, stmt.Deparsed)
+  }
+
+  return strings.Join(lines, "\n"), nil
+}
+
+// Relations share a namespace, and DROP TYPE drops every kind of type.
+var BASELINE_OBJECT_KINDS = [][]StmtType{
+  { TABLE, VIEW, MATERIALIZED_VIEW, FOREIGN_TABLE, SEQUENCE },
+  { TYPE, GENERIC_TYPE, ENUM, DOMAIN },
+}
+
+func isSameObjectKind(a StmtType, b StmtType) bool {
+  if a == b {
+    return true
+  }
+
+  for _, kind := range BASELINE_OBJECT_KINDS {
+    if slices.Contains(kind, a) && slices.Contains(kind, b) {
+      return true
+    }
+  }
+
+  return false
+}
+
+// Is stmt the one that creates the object of type stmt_type named name?
+func createsObject(stmt *ParsedStmt, stmt_type StmtType, name string) bool {
+  return stmt.HasName && stmt.StmtType != DROP && stmt.Name == name && isSameObjectKind(stmt.StmtType, stmt_type)
+}
+
+// Marks the statements in stmts that depend on a marked one, until there are
+// no more.
+func markBaselineDependents(stmts []*ParsedStmt, marked map[*ParsedStmt]bool) {
+  for changed := true; changed; {
+    changed = false
+
+    for _, stmt := range stmts {
+      if marked[stmt] {
+        continue
+      }
+
+      for _, dep := range stmt.Dependencies {
+        found := slices.ContainsFunc(stmts, func(o *ParsedStmt) bool {
+          return marked[o] && createsObject(o, dep.StmtType, dep.StmtName)
+        })
+
+        if found {
+          marked[stmt] = true
+          changed = true
+          break
+        }
+      }
+    }
+  }
+}
+
+// The statements of the squashed migrations reduced to the schema they leave
+// behind. An object that is dropped again is left out along with the
+// statements that depend on it, and an object that is created again with
+// CREATE OR REPLACE keeps its last definition, moving the statements that
+// depend on it after that. A DROP of something the statements don't create is
+// kept.
+func foldBaselineStmts(stmts []*ParsedStmt) []*ParsedStmt {
+  var kept []*ParsedStmt
+
+  for _, stmt := range stmts {
+    marked := make(map[*ParsedStmt]bool)
+
+    if stmt.StmtType == DROP {
+      for _, dep := range stmt.Dependencies {
+        found := false
+
+        for _, k := range kept {
+          if createsObject(k, dep.StmtType, dep.StmtName) {
+            marked[k] = true
+            found = true
+          }
+        }
+
+        if !found {
+          marked = nil
+          break
+        }
+      }
+
+      if len(marked) > 0 {
+        markBaselineDependents(kept, marked)
+        kept = slices.DeleteFunc(kept, func(k *ParsedStmt) bool { return marked[k] })
+        continue
+      }
+    } else if stmt.HasName && strings.HasPrefix(strings.ToUpper(stmt.Deparsed), "CREATE OR REPLACE ") {
+      var replaced []*ParsedStmt
+
+      for _, k := range kept {
+        if createsObject(k, stmt.StmtType, stmt.Name) {
+          marked[k] = true
+          replaced = append(replaced, k)
+        }
+      }
+
+      if len(replaced) > 0 {
+        markBaselineDependents(kept, marked)
+
+        var moved []*ParsedStmt
+
+        kept = slices.DeleteFunc(kept, func(k *ParsedStmt) bool {
+          if marked[k] && !slices.Contains(replaced, k) {
+            moved = append(moved, k)
+          }
+
+          return marked[k]
+        })
+
+        kept = append(append(kept, stmt), moved...)
+        continue
+      }
+    }
+
+    kept = append(kept, stmt)
+  }
+
+  return kept
+}
+
+// Squash returns the baseline that was written, or an empty string when there was nothing to squash.
+func Squash(ctx *Context) (string, error) {
+  if err := checkMigrationsWritable(ctx); err != nil {
+    return "", err
+  }
+
+  if err := setup(ctx); err != nil {
+    return "", err
+  }
+
+  through, err := strconv.Atoi(ctx.SquashThrough)
+
+  if err != nil {
+    return "", fmt.Errorf("'through' must be a migration number, got '%s'", ctx.SquashThrough)
+  }
+
+  var files []string
+
+  all_files, err := getMigrationFilesSorted(ctx)
+
+  if err != nil {
+    return "", err
+  }
+
+  for _, file := range all_files {
+    number, ok := migrationNumber(file)
+
+    if ok && number <= through {
+      files = append(files, file)
+    }
+  }
+
+  if len(files) < 2 {
+    getLogger(ctx).Info("Nothing to squash")
+    return "", nil
+  }
+
+  executed, err := getListOfExecutedMigrationFiles(ctx)
+
+  if err != nil {
+    return "", err
+  }
+
+  // A Go migration can't be folded into the baseline, and left out it would
+  // run before the baseline on new databases. The CLI registers none, so the
+  // ones this database ran are checked as well.
+  go_migrations := getGoMigrationsSorted(ctx)
+
+  for _, em := range executed {
+    if isGoMigrationName(em.fileName) {
+      go_migrations = append(go_migrations, em.fileName)
+    }
+  }
+
+  for _, migration := range go_migrations {
+    if number, ok := migrationNumber(migration); ok && number <= through {
+      return "", fmt.Errorf("can't squash through %s, the Go migration %s would run before the baseline on new databases. Squash through a migration before it", ctx.SquashThrough, extractFileFromPath(migration))
+    }
+  }
+
+  // Record the baseline as executed on this database before the old files are removed.
+  alreadyApplied := true
+
+  for _, file := range files {
+    found := false
+    for _, em := range executed {
+      if extractFileFromPath(em.fileName) == extractFileFromPath(file) {
+        found = true
+        break
+      }
+    }
+
+    alreadyApplied = alreadyApplied && found
+  }
+
+  baseline := fmt.Sprintf("%04d_baseline.sql", through)
+
+  // Squashing through the same number again would replace the baseline with
+  // one that only lists itself as squashed.
+  if _, err := os.Stat(filepath.Join(ctx.MigrationPath, baseline)); err == nil {
+    return "", fmt.Errorf("%s already exists. Squash through a later migration", baseline)
+  } else if !os.IsNotExist(err) {
+    return "", err
+  }
+
+  code, err := buildBaseline(ctx, files)
+
+  if err != nil {
+    return "", err
+  }
+
+  if err := os.WriteFile(filepath.Join(ctx.MigrationPath, baseline), []byte(code), 0644); err != nil {
+    return "", err
+  }
+
+  for _, file := range files {
+    if file != baseline {
+      if err := os.Remove(filepath.Join(ctx.MigrationPath, file)); err != nil {
+        return "", err
+      }
+    }
+  }
+
+  if alreadyApplied {
+    if err := recordMigrationAsExecuted(ctx, baseline); err != nil {
+      return "", err
+    }
+  }
+
+  getLogger(ctx).Info("Squashed migrations", "file", baseline, "migrations", len(files))
+  emit(ctx, Event { Type: EVENT_SQUASHED, File: baseline, Detail: strconv.Itoa(len(files)) })
+
+  return baseline, nil
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestBuildBaseline(t *testing.T) {
+  t.Run("dropped and replaced objects", func(t *testing.T) {
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      "0000.sql": { Data: []byte(`
+        CREATE TABLE person (id int);
+        CREATE TABLE old_person (id int);
+        CREATE INDEX old_person_id_idx ON old_person (id);
+        CREATE FUNCTION person_count() RETURNS bigint LANGUAGE sql AS 'SELECT 1';
+        CREATE VIEW person_counts AS SELECT person_count();
+      `) },
+      "0001.sql": { Data: []byte(`
+        -- schemaflow:allow-destructive
+        DROP TABLE old_person;
+        CREATE OR REPLACE FUNCTION person_count() RETURNS bigint LANGUAGE sql AS 'SELECT count(*) FROM person';
+        INSERT INTO person (id) VALUES (1);
+      `) },
+    } }
+
+    baseline, e := buildBaseline(ctx, []string{ "0000.sql", "0001.sql" })
+    perr(e)
+
+    correct := strings.Join([]string{
+      "-- schemaflow:squashed 0000.sql",
+      "-- schemaflow:squashed 0001.sql",
+      "CREATE TABLE person (id int);",
+      "CREATE OR REPLACE FUNCTION person_count() RETURNS bigint LANGUAGE sql AS $$SELECT count(*) FROM person$$;",
+      "CREATE VIEW person_counts AS SELECT person_count();",
+      "INSERT INTO person (id) VALUES (1);",
+    }, "\n")
+
+    if baseline != correct {
+      test_failed(t, baseline, correct)
+    }
+  })
+
+  t.Run("destructive statements left", func(t *testing.T) {
+    ctx := &Context{ MigrationFS: fstest.MapFS{
+      "0000.sql": { Data: []byte("CREATE TABLE person (id int);") },
+      "0001.sql": { Data: []byte("-- schemaflow:allow-destructive\nDROP TABLE legacy_person;\nDELETE FROM person;") },
+    } }
+
+    _, e := buildBaseline(ctx, []string{ "0000.sql", "0001.sql" })
+
+    if e == nil || !strings.Contains(e.Error(), "legacy_person") || !strings.Contains(e.Error(), "DELETE FROM person") {
+      test_failed(t, e, "an error listing the DROP and the DELETE")
+    }
+  })
+}
+
+// Runs squash against a SQLite file that executed the migrations being
+// squashed.
+func TestSqliteSquash(t *testing.T) {
+  setup := func(t *testing.T) *Context {
+    dir := t.TempDir()
+    migration_path := filepath.Join(dir, "migrations")
+    perr(os.MkdirAll(migration_path, 0755))
+
+    perr(os.WriteFile(filepath.Join(migration_path, "0000.sql"), []byte(`
+      CREATE TABLE person (id integer primary key);
+      CREATE TABLE old_person (id integer primary key);
+    `), 0644))
+
+    perr(os.WriteFile(filepath.Join(migration_path, "0001.sql"), []byte(`
+      -- schemaflow:allow-destructive
+      DROP TABLE old_person;
+      CREATE INDEX person_id_idx ON person (id);
+    `), 0644))
+
+    ctx := &Context{
+      DbContext: &DbContext{ PgDbName: filepath.Join(dir, "test.db") },
+      Dialect: sqliteDialect{},
+      MigrationPath: migration_path,
+      Action: MIGRATE,
+    }
+
+    _, e := migrateTarget(ctx)
+    perr(e)
+
+    return ctx
+  }
+
+  squash := func(ctx *Context, through string) (string, error) {
+    db, e := CreateDbConnections(ctx)
+    perr(e)
+    defer db.Close()
+
+    tx, e := db.Begin()
+    perr(e)
+
+    ctx.Db = db
+    ctx.DbTx = tx
+    ctx.Action = SQUASH
+    ctx.SquashThrough = through
+
+    perr(Initialize(ctx))
+    baseline, e := Squash(ctx)
+
+    if e != nil {
+      perr(tx.Rollback())
+      return "", e
+    }
+
+    perr(tx.Commit())
+    return baseline, nil
+  }
+
+  t.Run("squash executed migrations", func(t *testing.T) {
+    ctx := setup(t)
+
+    baseline, e := squash(ctx, "0001")
+    perr(e)
+
+    if baseline != "0001_baseline.sql" {
+      test_failed(t, baseline, "0001_baseline.sql")
+    }
+
+    code, e := os.ReadFile(filepath.Join(ctx.MigrationPath, baseline))
+    perr(e)
+
+    correct := strings.Join([]string{
+      "-- schemaflow:squashed 0000.sql",
+      "-- schemaflow:squashed 0001.sql",
+      "CREATE TABLE person (id integer primary key);",
+      "CREATE INDEX person_id_idx ON person (id);",
+    }, "\n")
+
+    if string(code) != correct {
+      test_failed(t, string(code), correct)
+    }
+
+    files, e := getMigrationFilesSorted(ctx)
+    perr(e)
+
+    if len(files) != 1 || files[0] != baseline {
+      test_failed(t, files, []string{ baseline })
+    }
+
+    // The database already has everything, so the baseline is only recorded.
+    ctx.Action = MIGRATE
+    result, e := migrateTarget(ctx)
+    perr(e)
+
+    if len(result.Executed) != 0 {
+      test_failed(t, result.Executed, []string{})
+    }
+  })
+
+  t.Run("baseline already exists", func(t *testing.T) {
+    ctx := setup(t)
+
+    existing := filepath.Join(ctx.MigrationPath, "0001_baseline.sql")
+    perr(os.WriteFile(existing, []byte("-- schemaflow:squashed 0000_old.sql\nCREATE TABLE pet (id integer primary key);"), 0644))
+
+    if _, e := squash(ctx, "0001"); e == nil || !strings.Contains(e.Error(), "already exists") {
+      test_failed(t, e, "an error that 0001_baseline.sql already exists")
+    }
+
+    code, e := os.ReadFile(existing)
+    perr(e)
+
+    if !strings.Contains(string(code), "CREATE TABLE pet") {
+      test_failed(t, string(code), "the baseline left as it was")
+    }
+  })
+}
@@ -2,10 +2,10 @@ package core
 
 import (
 	"fmt"
-	"log"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	pg_query "github.com/pganalyze/pg_query_go/v5"
@@ -39,20 +39,22 @@ func pgAconstToString(ac *pg_query.A_Const) string {
   }
 }
 
+// Joins a possibly qualified name. Built in names are returned without
+// pg_catalog, the way schemas usually write them.
 func pgNodesToString(nodes []*pg_query.Node) string {
-  if len(nodes) == 0 {
-    return ""
-  }
-
-  return nodes[len(nodes) - 1].GetString_().Sval
+  var name []string
 
-  //var name []string 
+  for _, node := range nodes {
+    if sval := node.GetString_().GetSval(); sval != "" {
+      name = append(name, sval)
+    }
+  }
 
-  //for _, node := range nodes {
-  //  name = append(name, node.GetString_().Sval)
-  //}
+  if len(name) > 1 && name[0] == "pg_catalog" {
+    name = name[1:]
+  }
 
-  //return strings.Join(name, ".")
+  return strings.Join(name, ".")
 }
 
 func pgRangevarToString(rv *pg_query.RangeVar) string {
@@ -104,7 +106,7 @@ func buildName(names ...string) string {
 }
 
 func buildDependency(t StmtType, name string) *Dependency {
-  return &Dependency { t, name, nil }
+  return &Dependency { StmtType: t, StmtName: name }
 }
 
 func appendDependency(ps *ParsedStmt, t StmtType, name string) {
@@ -133,19 +135,18 @@ func appendRangevarDependency(ps *ParsedStmt, rv *pg_query.RangeVar) {
 func unrollStatementDependencies(stmt *ParsedStmt, stmts []*ParsedStmt) []*ParsedStmt {
   unrolled := make([]*ParsedStmt, 0) 
 
-  if stmt.Handled {
+  if stmt == nil || stmt.Handled {
     return unrolled
   }
 
-  if stmt == nil {
-    return unrolled
-  }
+  // Marked first, so that a statement depending on itself, or a cycle
+  // checkDependencyCycles didn't get to see, can't recurse without end.
+  stmt.Handled = true
 
   for _, dep := range stmt.Dependencies {
     unrolled = append(unrolled, unrollStatementDependencies(dep.Dependency, stmts)...) 
   }
 
-  stmt.Handled = true
   unrolled = append(unrolled, stmt)
 
   return unrolled
@@ -445,6 +446,8 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
       for _, parameter := range parameters {
         hydrateStmtObject(parameter, ps)
       }
+
+      hydrateFunctionBody(n.CreateFunctionStmt, ps)
     }
 
     case *pg_query.Node_FunctionParameter: {
@@ -608,7 +611,7 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
     }
 
     case *pg_query.Node_AlterTableCmd: {
-
+      hydrateStmtObject(n.AlterTableCmd.GetDef(), ps)
     }
 
     case *pg_query.Node_AlterSeqStmt: {
@@ -653,7 +656,6 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
 
       relation := n.AlterTableStmt.GetRelation()
       schema_name := relation.GetSchemaname()
-      table := relation.GetRelname()
 
       cmds := n.AlterTableStmt.GetCmds()
 
@@ -662,7 +664,7 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
       }
 
       appendDependency(ps, SCHEMA, schema_name)
-      appendDependency(ps, TABLE, table)
+      appendDependency(ps, TABLE, pgRangevarToString(relation))
     }
 
     case *pg_query.Node_IndexElem: {
@@ -673,8 +675,7 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
       ps.Name = n.IndexStmt.GetIdxname()
       ps.StmtType = INDEX
 
-      relation := n.IndexStmt.GetRelation()
-      appendDependency(ps, SCHEMA, relation.GetSchemaname())
+      appendRangevarDependency(ps, n.IndexStmt.GetRelation())
 
       for _, ip := range n.IndexStmt.GetIndexParams() {
         hydrateStmtObject(ip, ps)
@@ -852,11 +853,8 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
 
     case *pg_query.Node_UpdateStmt: {
       relation := n.UpdateStmt.GetRelation()
-      schema := relation.GetSchemaname()
-      table := relation.GetRelname()
 
-      appendDependency(ps, SCHEMA, schema)
-      appendDependency(ps, TABLE, table)
+      appendRangevarDependency(ps, relation)
 
       from := n.UpdateStmt.GetFromClause()
       targets := n.UpdateStmt.GetTargetList()
@@ -899,8 +897,7 @@ func hydrateStmtObject(node *pg_query.Node, ps *ParsedStmt) {
     }
 
     default: {
-      // log.Printf("PARSE TREE: %v\n\n", ps.Json)
-      log.Printf("WARNING: Unknown node type %v this warning should be reported.\n", node) 
+      // Reported by buildParsedStmts, which has the logger.
       ps.StmtType = UNKNOWN_TYPE
     }
   }
@@ -948,74 +945,124 @@ func sortStmtsByPriority(stmts []*ParsedStmt) []*ParsedStmt {
   return sorted_stmts
 }
 
-func setStmtStatus(ctx *Context, stmt *ParsedStmt) {
+func setStmtStatus(ctx *Context, stmt *ParsedStmt) error {
   if ctx == nil {
-    return
+    return nil
+  }
+
+  stmt_hash_found, err := isStmtHashFoundInDb(ctx, stmt)
+
+  if err != nil {
+    return err
+  }
+
+  stmt_name_found, err := isStmtNameFoundInDb(ctx, stmt)
+
+  if err != nil {
+    return err
   }
 
-  stmt_hash_found := isStmtHashFoundInDb(ctx, stmt)
-  stmt_name_found := isStmtNameFoundInDb(ctx, stmt)
+  if !stmt_name_found {
+    if stmt_name_found, err = renameUnqualifiedStoredStmt(ctx, stmt, stmt_hash_found); err != nil {
+      return err
+    }
+  }
 
   if (stmt_name_found && stmt_hash_found) || (!stmt_name_found && stmt_hash_found) {
     stmt.Status = UNCHANGED
   } else if stmt_name_found && !stmt_hash_found {
-    stmt.PrevStmt = getPrevStmtVersion(ctx, stmt)
+    stmt.PrevDeparsed, err = getPrevStmtVersion(ctx, stmt)
     stmt.Status = CHANGED
   } else {
     stmt.Status = NEW
   }
+
+  return err
 }
 
-func buildParsedStmts(ctx *Context) *[]*ParsedStmt {
+func buildParsedStmts(ctx *Context) (*[]*ParsedStmt, error) {
   var ps []*ParsedStmt
 
   err := filepath.Walk(ctx.SqlPath, func(path string, info fs.FileInfo, err error) error {
-    perr(err)
+    if err != nil {
+      return err
+    }
 
     if !strings.HasSuffix(info.Name(), ".sql") {
       return nil
     }
 
-    log.Printf("Processing file %s\n", path)
+    getLogger(ctx).Info("Processing file", "file", path)
 
     fdata, err := os.ReadFile(path)
 
-    perr(err)
+    if err != nil {
+      return err
+    }
 
-    parsed_file, parse_err := parseSql(string(fdata))
+    extracted, parse_err := getDialect(ctx).ParseStmts(string(fdata))
 
     if parse_err != nil {
-      log.Panicf("Syntax Error in %v:\n\n %v\n", path, parse_err)
+      return &SyntaxError { path, parse_err }
+    }
+
+    for _, stmt := range extracted {
+      if stmt.StmtType == UNKNOWN_TYPE {
+        getLogger(ctx).Warn("Unknown node type, this warning should be reported", "file", path, "stmt", stmt.Deparsed)
+      }
+
+      stmt.File = path
+      qualifyStmtName(ctx, stmt)
+
+      if err := setStmtStatus(ctx, stmt); err != nil {
+        return fmt.Errorf("%s: %w", path, err)
+      }
+
+      emit(ctx, Event { Type: EVENT_STATEMENT_PARSED, File: path, Stmt: stmt.Deparsed, StmtName: stmt.Name })
     }
 
-    extracted := extractStmts(ctx, parsed_file)
+    emit(ctx, Event { Type: EVENT_FILE_PARSED, File: path, Detail: strconv.Itoa(len(extracted)) })
 
     ps = append(ps, extracted...)
 
     return nil
   })
 
-  log.Println("Building dependency graph...");
+  if err != nil {
+    return nil, err
+  }
+
+  getLogger(ctx).Info("Building dependency graph", "statements", len(ps))
+  qualifyDependencyNames(ctx, ps)
   hydrateDependencies(ps)
 
-  perr(err)
+  if err := checkDependencyCycles(ps); err != nil {
+    return nil, err
+  }
 
   sorted_stmts := sortStmtsByPriority(ps)
-  return &sorted_stmts
+  return &sorted_stmts, nil
 }
 
-func extractStmts(ctx *Context, pr *pg_query.ParseResult) []*ParsedStmt {
+func extractStmts(ctx *Context, pr *pg_query.ParseResult) ([]*ParsedStmt, error) {
   var ps []*ParsedStmt
   dependencies := make([]*Dependency, 0)
 
   for _, x := range pr.Stmts {
     dp, err := deparseRawStmt(x)
-    perr(err)
+
+    if err != nil {
+      return nil, err
+    }
+
     json, err := pg_query.ParseToJSON(dp)
-    perr(err)
+
+    if err != nil {
+      return nil, err
+    }
+
     nps := &ParsedStmt{ 
       Stmt: x, 
-      PrevStmt: nil,
       HasName: false,
       Name: "",
       Deparsed: dp, 
@@ -1029,10 +1076,9 @@ func extractStmts(ctx *Context, pr *pg_query.ParseResult) []*ParsedStmt {
     }
 
     hydrateStmtObject(x.GetStmt(), nps)
-    setStmtStatus(ctx, nps)
 
     ps = append(ps, nps) 
   }
 
-  return ps
+  return ps, nil
 }
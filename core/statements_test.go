@@ -28,7 +28,8 @@ func TestViewDependency(t *testing.T) {
   t.Run("view dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(domain_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -62,7 +63,8 @@ func TestTableForeignKeyDependency(t *testing.T) {
   t.Run("table foreign key dependency", func(t *testing.T) {
     fke_parsed, e := pg_query.Parse(foreign_key_example)
     perr(e)
-    stmts := extractStmts(nil, fke_parsed)
+    stmts, e := extractStmts(nil, fke_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency {
@@ -92,7 +94,8 @@ func TestTableInheritedDependency(t *testing.T) {
   t.Run("table inherited dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(inherited_table_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency {
@@ -121,7 +124,8 @@ func TestTablePartitionDependency(t *testing.T) {
   t.Run("table partition dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(partition_table_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -149,7 +153,8 @@ func TestTableFunctionDependency(t *testing.T) {
   t.Run("table function dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(default_function_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -179,7 +184,8 @@ func TestTableSequenceDependency(t *testing.T) {
   t.Run("table sequence dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(default_function_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -209,7 +215,8 @@ func TestTableCustomTypeDependency(t *testing.T) {
   t.Run("table domain dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(domain_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -238,7 +245,8 @@ func TestTableCollateDependency(t *testing.T) {
   t.Run("table collate dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(domain_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -268,7 +276,8 @@ func TestTableSchemaDependency(t *testing.T) {
   t.Run("table schema dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(domain_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -298,7 +307,8 @@ func TestTableTablespaceDependency(t *testing.T) {
   t.Run("table tablespace dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(domain_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -327,7 +337,8 @@ func TestInsertDependency(t *testing.T) {
   t.Run("table insert dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(insert_example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -360,7 +371,8 @@ func TestWithDependency(t *testing.T) {
   t.Run("with dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -393,7 +405,8 @@ func TestCommentDependency(t *testing.T) {
   t.Run("with dependency", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(example)
     perr(e)
-    stmts := extractStmts(nil, ite_parsed)
+    stmts, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := stmts[0]
 
     correct := []Dependency{
@@ -421,7 +434,8 @@ func TestRuleDependency(t *testing.T) {
   t.Run("rule", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(example)
     perr(e)
-    result := extractStmts(nil, ite_parsed)
+    result, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := result[0]
 
     correct := []Dependency{
@@ -450,7 +464,8 @@ func TestRuleWithWhereDependency(t *testing.T) {
   t.Run("rule", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(example)
     perr(e)
-    result := extractStmts(nil, ite_parsed)
+    result, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := result[0]
 
     correct := []Dependency{
@@ -481,7 +496,8 @@ func TestCreateSequence(t *testing.T) {
   t.Run("rule", func(t *testing.T) {
     ite_parsed, e := pg_query.Parse(example)
     perr(e)
-    result := extractStmts(nil, ite_parsed)
+    result, e := extractStmts(nil, ite_parsed)
+    perr(e)
     ps := result[0]
 
     correct := []Dependency{
@@ -500,3 +516,127 @@ func TestCreateSequence(t *testing.T) {
 
   })
 }
+
+func TestPlpgsqlFunctionDependency(t *testing.T) {
+  example := `
+  create or replace function public.insert_person(i_name text, i_age integer) returns bigint as $$
+  declare
+    i_id integer;
+    i_pet pet%rowtype;
+    i_mood mood;
+  begin
+    insert into person default values returning id into i_id;
+    insert into age (id, age) values (i_id, i_age);
+    i_mood := current_mood(i_id);
+    return i_id;
+  end;
+  $$ language plpgsql;
+  `
+
+  t.Run("plpgsql body dependency", func(t *testing.T) {
+    ite_parsed, e := pg_query.Parse(example)
+    perr(e)
+    result, e := extractStmts(nil, ite_parsed)
+    perr(e)
+    ps := result[0]
+
+    soft := func(t StmtType, name string) Dependency {
+      dep := buildDependency(t, name)
+      dep.Soft = true
+      return *dep
+    }
+
+    correct := []Dependency{
+      *buildDependency(GENERIC_TYPE, "int8"),
+      soft(TABLE, "person"),
+      soft(TABLE, "age"),
+      soft(FUNCTION, "current_mood"),
+      soft(GENERIC_TYPE, "int4"),
+      soft(TABLE, "pet"),
+      soft(GENERIC_TYPE, "mood"),
+    }
+
+    var checked []Dependency
+
+    for _, c := range ps.Dependencies {
+      checked = append(checked, *c)
+    }
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct) 
+    }
+  })
+}
+
+func TestSqlFunctionDependency(t *testing.T) {
+  example := `
+  create function person_count() returns bigint as $$
+    select count(*) from person where is_active(person);
+  $$ language sql;
+
+  create function pet_count() returns bigint
+  begin atomic
+    select count(*) from pet;
+  end;
+  `
+
+  t.Run("sql body dependency", func(t *testing.T) {
+    ite_parsed, e := pg_query.Parse(example)
+    perr(e)
+    result, e := extractStmts(nil, ite_parsed)
+    perr(e)
+
+    correct := [][]Dependency{
+      {
+        *buildDependency(GENERIC_TYPE, "int8"),
+        *buildDependency(FUNCTION, "is_active"),
+        *buildDependency(FUNCTION, "count"),
+        *buildDependency(TABLE, "person"),
+      },
+      {
+        *buildDependency(GENERIC_TYPE, "int8"),
+        *buildDependency(FUNCTION, "count"),
+        *buildDependency(TABLE, "pet"),
+      },
+    }
+
+    var checked [][]Dependency
+
+    for _, ps := range result {
+      var deps []Dependency
+
+      for _, c := range ps.Dependencies {
+        deps = append(deps, *c)
+      }
+
+      checked = append(checked, deps)
+    }
+
+    if !reflect.DeepEqual(correct, checked) {
+      test_failed(t, checked, correct) 
+    }
+  })
+}
+
+func TestIndexTableDependency(t *testing.T) {
+  t.Run("index after its table", func(t *testing.T) {
+    stmts := append(
+      parseSchemaFile("schema/indexes.sql", "CREATE INDEX person_name_idx ON app.person (name);"),
+      parseSchemaFile("schema/person.sql", "CREATE TABLE app.person (id int PRIMARY KEY, name text);")...,
+    )
+
+    hydrateDependencies(stmts)
+
+    var order []string
+
+    for _, stmt := range sortStmtsByPriority(stmts) {
+      order = append(order, stmt.Name)
+    }
+
+    correct := []string{ "app.person", "person_name_idx" }
+
+    if !reflect.DeepEqual(order, correct) {
+      test_failed(t, order, correct)
+    }
+  })
+}
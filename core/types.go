@@ -1,7 +1,10 @@
 package core
 
 import (
+	"context"
 	"database/sql"
+	"io/fs"
+	"time"
 
 	pg_query "github.com/pganalyze/pg_query_go/v5"
 )
@@ -83,6 +86,9 @@ const (
 const ACTION_CLEAN = "clean"
 const ACTION_MIGRATE = "migrate"
 const ACTION_MAKE_MIGRATIONS = "make"
+const ACTION_SQUASH = "squash"
+const ACTION_CHECK = "check"
+const ACTION_LINT = "lint"
 
 type ActionType int
 
@@ -90,6 +96,10 @@ const (
   MIGRATE ActionType = iota
   MAKEMIGRATIONS
   CLEAN
+  SQUASH
+  CHECK
+  LINT
+  STATUS
 )
 
 type StmtStatus int
@@ -102,35 +112,106 @@ const (
 )
 
 type DbContext struct { 
+  // A postgres:// URL. Options given separately override its parts.
+  PgUrl string
   PgHost string 
   PgPort int
   PgUser string
   PgPassword string 
   PgDbName string
+  // Shorthand for PgSSLMode require.
   PgSSL bool
+  // One of SSL_MODES. Defaults to $PGSSLMODE, or disable.
+  PgSSLMode string
+  PgSSLRootCert string
+  PgSSLCert string
+  PgSSLKey string
+  // Decrypts PgSSLKey.
+  PgSSLPassword string
 }
 
 type Context struct {
   DbContext *DbContext
   DbTx *sql.Tx
   Db *sql.DB
+  // Cancels every query schemaflow runs. Defaults to context.Background().
+  Ctx context.Context
+  // Defaults to postgres.
+  Dialect Dialect
+  // Cancels Ctx after this long when set by the CLI.
+  Timeout time.Duration
+  // Keep retrying the connection for this long while the database starts.
+  WaitTimeout time.Duration
+  // Defaults to slog.Default().
+  Logger Logger
+  Observer Observer
   SqlPath string
   MigrationPath string
+  MigrationFS fs.FS
+  SnapshotPath string
+  SquashThrough string
+  LintDisabled []string
+  DryRun bool
+  AllowDestructive bool
   Action ActionType
   Stmts *[]*ParsedStmt
+  // MigrateDatabases runs against each of these in place of DbContext.PgDbName.
+  Databases []string
+  // Run against DbContext to add database names to Databases.
+  DatabasesQuery string
+  // Databases or schemas migrated at once. Defaults to DEFAULT_CONCURRENCY.
+  Concurrency int
+  // Keep starting databases or schemas after one has failed.
+  ContinueOnError bool
+  // MigrateSchemas runs against each of these in DbContext's database.
+  Schemas []string
+  // A LIKE pattern matching schema names to add to Schemas.
+  SchemasPattern string
+  // Run against DbContext to add schema names to Schemas.
+  SchemasQuery string
+  // Holds the bookkeeping tables. Defaults to DEFAULT_BOOKKEEPING_SCHEMA. For
+  // mysql and sqlite, which have no schemas, it prefixes the table names.
+  BookkeepingSchema string
+  // Prefixes the bookkeeping table names, e.g. platform_ for platform_migrations.
+  TablePrefix string
+  // The schema migrate targets. search_path is set to it and it's recorded
+  // with each executed migration.
+  Schema string
+  // Migrations run as this role, so it owns the objects they create. A role
+  // directive in a migration overrides it.
+  Role string
+  // Make checks that Role can create every new object.
+  CheckOwner bool
+  // Schemas unqualified names in the schema files resolve against. Defaults
+  // to DEFAULT_SEARCH_PATH.
+  SearchPath []string
+  // Go migrations by version, in place of the ones registered with
+  // RegisterMigration.
+  GoMigrations map[string]GoMigrationFunc
+  // Set by Initialize when a read only action found no bookkeeping tables
+  // and didn't create them.
+  bookkeepingMissing bool
 }
 
 type Dependency struct {
   StmtType StmtType
   StmtName string
   Dependency *ParsedStmt
+  // Found in a plpgsql body, which postgres doesn't check until it runs. Soft
+  // dependencies order statements but don't make cycles.
+  Soft bool
 }
 
 type ParsedStmt struct {
   Stmt *pg_query.RawStmt
-  PrevStmt *pg_query.RawStmt
+  // The deparsed version stored by the last make, set for CHANGED statements.
+  PrevDeparsed string
+  // The schema file the statement was read from.
+  File string
   HasName bool
   Name string
+  // The name as written in the schema file, before qualifyStmtName.
+  WrittenName string
   Deparsed string
   Json string
   Hash string
@@ -141,4 +222,36 @@ type ParsedStmt struct {
   Status StmtStatus
 }
 
+type MakeResult struct {
+  // The migration file that was written, empty when no migrations were required.
+  File string
+  Statements int
+}
+
+type MigrateResult struct {
+  Executed []string
+  // Baselines recorded as executed without running them.
+  Recorded []string
+  // Migrations that would have been executed, only set for dry runs.
+  Pending []string
+}
+
+type DatabaseResult struct {
+  Database string
+  // Set by MigrateSchemas.
+  Schema string
+  Result *MigrateResult
+  Err error
+  // Not started because another database failed first.
+  Skipped bool
+  Duration time.Duration
+}
+
+type StatusResult struct {
+  Executed []string
+  Pending []string
+  Unresolved []string
+  Tampered []string
+}
+
 const MIGRATIONS_DB = "schemaflow_ephemeral_migration_db"
@@ -7,8 +7,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	pg_query "github.com/pganalyze/pg_query_go/v5"
 )
 
 func Perr(e error) {
@@ -28,11 +26,15 @@ func HashString(s string) string {
   return hex.EncodeToString(r)
 }
 
-func HashFile(p string) string {
+func HashFile(p string) (string, error) {
   data, e := os.ReadFile(p)
-  perr(e)
+
+  if e != nil {
+    return "", e
+  }
+
   sdata := string(data)
-  return HashString(sdata) 
+  return HashString(sdata), nil
 }
 
 
@@ -46,8 +48,13 @@ func DoesPathExist(path string) bool {
   return true
 }
 
-func ListAllFilesInPath(path string) []string {
+func ListAllFilesInPath(path string) ([]string, error) {
   var files []string
+
+  if !DoesPathExist(path) {
+    return files, nil
+  }
+
   err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
     if err != nil {
       return err
@@ -57,11 +64,10 @@ func ListAllFilesInPath(path string) []string {
       files = append(files, path)
     }
 
-    return nil 
+    return nil
   })
 
-  perr(err)
-  return files
+  return files, err
 }
 
 type statements struct {
@@ -71,21 +77,33 @@ type statements struct {
   stmtType int
 }
 
-func getListOfStatementsInDb(ctx *Context) []statements {
+func getListOfStatementsInDb(ctx *Context) ([]statements, error) {
   var stmts []statements
 
-  allStmts, e := ctx.Db.Query("select stmt, stmt_name, stmt_hash, stmt_type from schemaflow.statements")
-  perr(e)
+  if ctx.bookkeepingMissing {
+    return nil, nil
+  }
+
+  allStmts, e := ctx.DbTx.QueryContext(getCtx(ctx), bookkeepingSql(ctx, "select stmt, stmt_name, stmt_hash, stmt_type from {statements}"))
+
+  if e != nil {
+    return nil, e
+  }
+
+  defer allStmts.Close()
 
   for allStmts.Next() {
     var stmt, stmt_name, stmt_hash *string
     var stmt_type int
 
-    perr(allStmts.Scan(&stmt, &stmt_name, &stmt_hash, &stmt_type))
+    if e := allStmts.Scan(&stmt, &stmt_name, &stmt_hash, &stmt_type); e != nil {
+      return nil, e
+    }
+
     stmts = append(stmts, statements { stmt, stmt_name, stmt_hash, stmt_type})
   }
 
-  return stmts
+  return stmts, allStmts.Err()
 }
 
 type executedMigration struct {
@@ -93,91 +111,135 @@ type executedMigration struct {
   fileHash string
 }
 
-func getListOfExecutedMigrationFiles(ctx *Context) []executedMigration{
+// Only the postgres bookkeeping records the schema a migration targeted.
+func hasSchemaColumn(ctx *Context) bool {
+  return getDialect(ctx).Name() == DIALECT_POSTGRES
+}
+
+// Records a migration as executed against ctx.Schema.
+func insertExecutedMigration(ctx *Context, file_name string, file_hash string) error {
+  if hasSchemaColumn(ctx) {
+    _, err := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "insert into {migrations} (file_name, file_hash, schema_name) values ($1, $2, $3)"), file_name, file_hash, ctx.Schema)
+    return err
+  }
+
+  _, err := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "insert into {migrations} (file_name, file_hash) values ($1, $2)"), file_name, file_hash)
+  return err
+}
+
+func getListOfExecutedMigrationFiles(ctx *Context) ([]executedMigration, error) {
   var executedMigrations []executedMigration
 
-  migrations, e := ctx.Db.Query("select file_name, file_hash from schemaflow.migrations")
-  perr(e)
+  if ctx.bookkeepingMissing {
+    return nil, nil
+  }
+
+  query := "select file_name, file_hash from {migrations}"
+  var args []any
+
+  if hasSchemaColumn(ctx) {
+    query += " where schema_name=$1"
+    args = append(args, ctx.Schema)
+  }
+
+  migrations, e := ctx.DbTx.QueryContext(getCtx(ctx), bookkeepingSql(ctx, query), args...)
+
+  if e != nil {
+    return nil, e
+  }
+
+  defer migrations.Close()
 
   for migrations.Next() {
     var file_name, file_hash string;
 
-    migrations.Scan(&file_name, &file_hash)
+    if e := migrations.Scan(&file_name, &file_hash); e != nil {
+      return nil, e
+    }
 
     executedMigrations = append(executedMigrations, executedMigration { file_name, file_hash })
   }
 
-  return executedMigrations
+  return executedMigrations, migrations.Err()
 }
 
-func removeStmtByHash(ctx *Context, hash string) {
-  _, e := ctx.DbTx.Exec("delete from schemaflow.statements where stmt_hash=$1", hash)
-  perr(e)
+func removeStmtByHash(ctx *Context, hash string) error {
+  _, e := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "delete from {statements} where stmt_hash=$1"), hash)
+  return e
 }
 
-func updateStmtInDb(ctx *Context, stmt *ParsedStmt) {
+func updateStmtInDb(ctx *Context, stmt *ParsedStmt) error {
   if stmt.HasName {
-    _, err := ctx.DbTx.Exec("delete from schemaflow.statements where stmt_name=$1 and stmt_type=$2", stmt.Name, stmt.StmtType)
-    perr(err)
+    _, err := ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "delete from {statements} where stmt_name=$1 and stmt_type=$2"), stmt.Name, stmt.StmtType)
+
+    if err != nil {
+      return err
+    }
   }
 
-  addStmtToDb(ctx, stmt)
+  return addStmtToDb(ctx, stmt)
 }
 
-func addStmtToDb(ctx *Context, stmt *ParsedStmt) {
+func addStmtToDb(ctx *Context, stmt *ParsedStmt) error {
+  // Checked first instead of using "on conflict", which not every dialect supports.
+  found, err := isStmtHashFoundInDb(ctx, stmt)
+
+  if err != nil || found {
+    return err
+  }
+
   if stmt.HasName {
-    _, err := ctx.DbTx.Exec("insert into schemaflow.statements (stmt, stmt_hash, stmt_type, stmt_name) values ($1, $2, $3, $4) on conflict (stmt_hash) do nothing", stmt.Deparsed, stmt.Hash, stmt.StmtType, stmt.Name) 
-    perr(err)
+    _, err = ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "insert into {statements} (stmt, stmt_hash, stmt_type, stmt_name) values ($1, $2, $3, $4)"), stmt.Deparsed, stmt.Hash, stmt.StmtType, stmt.Name)
   } else {
-    _, err := ctx.DbTx.Exec("insert into schemaflow.statements (stmt, stmt_hash, stmt_type) values ($1, $2, $3) on conflict (stmt_hash) do nothing", stmt.Deparsed, stmt.Hash, stmt.StmtType) 
-
-    if err != nil {
-      fmt.Printf("ERROR WITH: %s %s %d\n", stmt.Deparsed, stmt.Hash, stmt.StmtType)
-    }
+    _, err = ctx.DbTx.ExecContext(getCtx(ctx), bookkeepingSql(ctx, "insert into {statements} (stmt, stmt_hash, stmt_type) values ($1, $2, $3)"), stmt.Deparsed, stmt.Hash, stmt.StmtType)
+  }
 
-    perr(err)
+  if err != nil {
+    return fmt.Errorf("storing statement %s: %w", stmt.Deparsed, err)
   }
 
+  return nil
 }
 
-func isStmtHashFoundInDb(ctx *Context, stmt *ParsedStmt) bool {
-  r, e := ctx.Db.Query("select * from schemaflow.statements where stmt_hash=$1", stmt.Hash)
-  defer r.Close()
-  perr(e)
-  return r.Next()
-}
+func isStmtHashFoundInDb(ctx *Context, stmt *ParsedStmt) (bool, error) {
+  if ctx.bookkeepingMissing {
+    return false, nil
+  }
 
-func isStmtNameFoundInDb(ctx *Context, stmt *ParsedStmt) bool {
-  if !stmt.HasName {
-    return false
+  r, e := ctx.DbTx.QueryContext(getCtx(ctx), bookkeepingSql(ctx, "select * from {statements} where stmt_hash=$1"), stmt.Hash)
+
+  if e != nil {
+    return false, e
   }
 
-  r, e := ctx.Db.Query("select * from schemaflow.statements where stmt_name=$1 and stmt_type=$2", stmt.Name, stmt.StmtType);
   defer r.Close()
-  perr(e)
-  return r.Next()
+  return r.Next(), r.Err()
 }
 
-func getPrevStmtVersion(ctx *Context, stmt *ParsedStmt) *pg_query.RawStmt {
-  var prev_stmt_text string
-  e := ctx.Db.QueryRow("select stmt from schemaflow.statements where stmt_name=$1 and stmt_type=$2", stmt.Name, stmt.StmtType).Scan(&prev_stmt_text);
-  perr(e)
-  parsed, e := pg_query.Parse(prev_stmt_text)
-  perr(e)
+func isStmtNameFoundInDb(ctx *Context, stmt *ParsedStmt) (bool, error) {
+  if !stmt.HasName || ctx.bookkeepingMissing {
+    return false, nil
+  }
 
-  stmts := parsed.GetStmts()
+  r, e := ctx.DbTx.QueryContext(getCtx(ctx), bookkeepingSql(ctx, "select * from {statements} where stmt_name=$1 and stmt_type=$2"), stmt.Name, stmt.StmtType);
 
-  if len(stmts) == 0 {
-    return nil
+  if e != nil {
+    return false, e
   }
 
-  return stmts[0]
+  defer r.Close()
+  return r.Next(), r.Err()
+}
+
+func getPrevStmtVersion(ctx *Context, stmt *ParsedStmt) (string, error) {
+  var prev_stmt_text string
+  e := ctx.DbTx.QueryRowContext(getCtx(ctx), bookkeepingSql(ctx, "select stmt from {statements} where stmt_name=$1 and stmt_type=$2"), stmt.Name, stmt.StmtType).Scan(&prev_stmt_text);
+  return prev_stmt_text, e
 }
 
-func readFileToString(ctx *Context, file string) string {
+func readFileToString(ctx *Context, file string) (string, error) {
   data, err := os.ReadFile(file)
-  perr(err)
-  return string(data)
+  return string(data), err
 }
 
 func extractFileFromPath(path string) string {
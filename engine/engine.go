@@ -0,0 +1,201 @@
+// Package engine runs schemaflow from inside another program. Every method
+// runs in its own transaction and returns an error instead of exiting, so
+// callers can inspect failures with errors.As.
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"io/fs"
+	"schemaflow/core"
+)
+
+type Config struct {
+  // An open connection to the database being migrated.
+  Db *sql.DB
+  // The database engine behind Db, "postgres" (default), "mysql" or "sqlite".
+  Dialect string
+  // The directory holding the schema files, used by Make and Check.
+  SqlPath string
+  // The directory holding the migration files.
+  MigrationPath string
+  // When set, migrations are read from this file system instead of
+  // MigrationPath, e.g. one built with //go:embed. Make can't write to it.
+  MigrationFS fs.FS
+  // The schema holding the bookkeeping tables, "schemaflow" by default.
+  // Projects sharing a database each need their own, or their own TablePrefix.
+  BookkeepingSchema string
+  // Prefixes the bookkeeping table names.
+  TablePrefix string
+  // When set, Migrate writes a schema snapshot to this file.
+  SnapshotPath string
+  // Migrations run as this role, so it owns the objects they create.
+  Role string
+  // Schemas unqualified names in SqlPath resolve against, public by default.
+  SearchPath []string
+  // Lets Migrate run migrations that drop, truncate, or delete without a WHERE clause.
+  AllowDestructive bool
+  // Receives progress messages. Defaults to slog.Default().
+  Logger Logger
+  // Called for every event, e.g. to report progress.
+  Observer Observer
+}
+
+type Engine struct {
+  config Config
+}
+
+type (
+  MakeResult = core.MakeResult
+  MigrateResult = core.MigrateResult
+  StatusResult = core.StatusResult
+  CheckResult = core.CheckResult
+
+  UnresolvedMigrationsError = core.UnresolvedMigrationsError
+  TamperedMigrationsError = core.TamperedMigrationsError
+  SyntaxError = core.SyntaxError
+  DestructiveMigrationsError = core.DestructiveMigrationsError
+  PartialBaselineError = core.PartialBaselineError
+  MigrationError = core.MigrationError
+  OwnerError = core.OwnerError
+  DependencyCycleError = core.DependencyCycleError
+
+  GoMigrationFunc = core.GoMigrationFunc
+
+  Logger = core.Logger
+  Observer = core.Observer
+  Event = core.Event
+  EventType = core.EventType
+)
+
+const (
+  EVENT_FILE_PARSED = core.EVENT_FILE_PARSED
+  EVENT_STATEMENT_PARSED = core.EVENT_STATEMENT_PARSED
+  EVENT_MIGRATION_STARTED = core.EVENT_MIGRATION_STARTED
+  EVENT_MIGRATION_FINISHED = core.EVENT_MIGRATION_FINISHED
+  EVENT_MIGRATION_RECORDED = core.EVENT_MIGRATION_RECORDED
+  EVENT_MIGRATION_WRITTEN = core.EVENT_MIGRATION_WRITTEN
+  EVENT_SQUASHED = core.EVENT_SQUASHED
+  EVENT_DRIFT_FOUND = core.EVENT_DRIFT_FOUND
+  EVENT_LINT_FINDING = core.EVENT_LINT_FINDING
+  EVENT_SNAPSHOT_WRITTEN = core.EVENT_SNAPSHOT_WRITTEN
+
+  DRIFT_UNRESOLVED = core.DRIFT_UNRESOLVED
+  DRIFT_TAMPERED = core.DRIFT_TAMPERED
+  DRIFT_SYNTAX_ERROR = core.DRIFT_SYNTAX_ERROR
+  DRIFT_SCHEMA_CHANGE = core.DRIFT_SCHEMA_CHANGE
+)
+
+func New(config Config) *Engine {
+  return &Engine { config }
+}
+
+// RegisterMigration ties a Go function to a migration version, see core.RegisterMigration.
+func RegisterMigration(version string, fn GoMigrationFunc) {
+  core.RegisterMigration(version, fn)
+}
+
+// Begins a transaction, initializes schemaflow inside it and runs fn. The
+// transaction is committed when fn succeeds, unless the action is read only.
+func (e *Engine) run(ctx context.Context, action core.ActionType, fn func(*core.Context) error) error {
+  dialect_name := e.config.Dialect
+
+  if dialect_name == "" {
+    dialect_name = core.DIALECT_POSTGRES
+  }
+
+  dialect, err := core.GetDialect(dialect_name)
+
+  if err != nil {
+    return err
+  }
+
+  tx, err := e.config.Db.BeginTx(ctx, nil)
+
+  if err != nil {
+    return err
+  }
+
+  cctx := &core.Context{
+    Db: e.config.Db,
+    DbTx: tx,
+    Ctx: ctx,
+    Dialect: dialect,
+    SqlPath: e.config.SqlPath,
+    MigrationPath: e.config.MigrationPath,
+    MigrationFS: e.config.MigrationFS,
+    SnapshotPath: e.config.SnapshotPath,
+    AllowDestructive: e.config.AllowDestructive,
+    Role: e.config.Role,
+    SearchPath: e.config.SearchPath,
+    BookkeepingSchema: e.config.BookkeepingSchema,
+    TablePrefix: e.config.TablePrefix,
+    Logger: e.config.Logger,
+    Observer: e.config.Observer,
+    Action: action,
+  }
+
+  if err := core.Initialize(cctx); err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  if err := fn(cctx); err != nil {
+    tx.Rollback()
+    return err
+  }
+
+  if core.IsReadOnly(cctx) {
+    return tx.Rollback()
+  }
+
+  return tx.Commit()
+}
+
+// Make writes a migration for every statement in SqlPath that changed since the last Make.
+func (e *Engine) Make(ctx context.Context) (*MakeResult, error) {
+  var result *MakeResult
+
+  err := e.run(ctx, core.MAKEMIGRATIONS, func(cctx *core.Context) (err error) {
+    result, err = core.MakeMigrations(cctx)
+    return err
+  })
+
+  return result, err
+}
+
+// Migrate executes every migration that has not been executed yet.
+func (e *Engine) Migrate(ctx context.Context) (*MigrateResult, error) {
+  var result *MigrateResult
+
+  err := e.run(ctx, core.MIGRATE, func(cctx *core.Context) (err error) {
+    result, err = core.Migrate(cctx)
+    return err
+  })
+
+  return result, err
+}
+
+// Status lists executed, pending, unresolved and tampered migrations without changing anything.
+func (e *Engine) Status(ctx context.Context) (*StatusResult, error) {
+  var result *StatusResult
+
+  err := e.run(ctx, core.STATUS, func(cctx *core.Context) (err error) {
+    result, err = core.Status(cctx)
+    return err
+  })
+
+  return result, err
+}
+
+// Check reports everything the check command fails on without changing anything.
+func (e *Engine) Check(ctx context.Context) (*CheckResult, error) {
+  var result *CheckResult
+
+  err := e.run(ctx, core.CHECK, func(cctx *core.Context) (err error) {
+    result, err = core.Check(cctx)
+    return err
+  })
+
+  return result, err
+}
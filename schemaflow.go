@@ -1,16 +1,133 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
 	"schemaflow/core"
+	"syscall"
+	"time"
 )
 
 func perr(err error) {
   core.Perr(err)
 }
 
+// Runs the action inside ctx.DbTx. The returned bool is false when a read only
+// command found problems.
+func run(ctx *core.Context) (bool, error) {
+  if err := core.Initialize(ctx); err != nil {
+    return false, err
+  }
+
+  switch ctx.Action {
+    case core.MIGRATE: {
+      _, err := core.Migrate(ctx)
+      return true, err
+    }
+
+    case core.MAKEMIGRATIONS: {
+      _, err := core.MakeMigrations(ctx)
+      return true, err
+    }
+
+    case core.CLEAN: {
+      core.Clean(ctx)
+    }
+
+    case core.SQUASH: {
+      _, err := core.Squash(ctx)
+      return true, err
+    }
+
+    case core.CHECK: {
+      result, err := core.Check(ctx)
+
+      if err != nil {
+        return false, err
+      }
+
+      return result.Passed(), nil
+    }
+
+    case core.LINT: {
+      return core.Lint(ctx)
+    }
+  }
+
+  return true, nil
+}
+
+// Exits when the run was interrupted or timed out. The transaction has been
+// rolled back by then, so nothing was changed.
+func exitIfCancelled(ctx *core.Context) {
+  switch ctx.Ctx.Err() {
+    case context.Canceled: {
+      log.Fatalln("Interrupted, nothing was committed.")
+    }
+
+    case context.DeadlineExceeded: {
+      log.Fatalf("Timed out after %s, nothing was committed.\n", ctx.Timeout)
+    }
+  }
+}
+
+// Migrates every database or schema in the list and prints how each one went.
+func migrateMany(ctx *core.Context, migrate func(ctx *core.Context) ([]*core.DatabaseResult, error)) {
+  results, err := migrate(ctx)
+
+  if err != nil {
+    exitIfCancelled(ctx)
+    log.Fatalln(err)
+  }
+
+  failed := 0
+
+  log.Println("Summary:")
+
+  for _, result := range results {
+    name := result.Database
+
+    if result.Schema != "" {
+      name = result.Schema
+    }
+
+    switch {
+      case result.Skipped: {
+        failed++
+        log.Printf("  %s: skipped\n", name)
+      }
+
+      case result.Err != nil: {
+        failed++
+        log.Printf("  %s: failed after %s: %s\n", name, result.Duration.Round(time.Millisecond), result.Err)
+      }
+
+      case ctx.DryRun: {
+        log.Printf("  %s: %d pending migrations\n", name, len(result.Result.Pending))
+      }
+
+      default: {
+        log.Printf("  %s: executed %d migrations in %s\n", name, len(result.Result.Executed), result.Duration.Round(time.Millisecond))
+      }
+    }
+  }
+
+  exitIfCancelled(ctx)
+
+  if failed > 0 {
+    log.Printf("%d of %d were not migrated.\n", failed, len(results))
+    os.Exit(1)
+  }
+
+  log.Println("Done.")
+}
+
 func main() {
-  /* 
+  /*
     TODO:
     - Bring back the versioned migrations system
     - Bring back the "makemigrations", "migrate", and "clean" commands"
@@ -21,31 +138,75 @@ func main() {
   */
 
   ctx := core.ParseArgs()
-  ctx.Db = core.CreateDbConnections(ctx.DbContext)
 
-  defer ctx.Db.Close()
+  // Cancelling the context makes database/sql roll back the transaction and
+  // lib/pq cancel the running query.
+  run_ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+  defer stop()
+
+  if ctx.Timeout > 0 {
+    var cancel context.CancelFunc
+    run_ctx, cancel = context.WithTimeout(run_ctx, ctx.Timeout)
+    defer cancel()
+  }
+
+  ctx.Ctx = run_ctx
+
+  if len(ctx.Databases) > 0 || ctx.DatabasesQuery != "" {
+    migrateMany(ctx, core.MigrateDatabases)
+    return
+  }
+
+  if len(ctx.Schemas) > 0 || ctx.SchemasPattern != "" || ctx.SchemasQuery != "" {
+    migrateMany(ctx, core.MigrateSchemas)
+    return
+  }
+
+  db, err := core.CreateDbConnections(ctx)
+
+  if err != nil {
+    exitIfCancelled(ctx)
+    log.Fatalln(err)
+  }
+
+  ctx.Db = db
+
+  db_tx, te := ctx.Db.BeginTx(ctx.Ctx, nil)
+
+  if te != nil {
+    ctx.Db.Close()
+    exitIfCancelled(ctx)
+    log.Fatalln(te)
+  }
 
-  db_tx, te := ctx.Db.Begin()
   ctx.DbTx = db_tx
-  perr(te)
 
-  core.Initialize(ctx) 
+  passed, err := run(ctx)
 
-  switch ctx.Action {
-    case core.MIGRATE: {
-      core.Migrate(ctx)
+  if err != nil || core.IsReadOnly(ctx) {
+    // Already rolled back when the context was cancelled.
+    if re := ctx.DbTx.Rollback(); re != nil && !errors.Is(re, sql.ErrTxDone) {
+      perr(re)
     }
+  } else {
+    err = ctx.DbTx.Commit()
+  }
 
-    case core.MAKEMIGRATIONS: {
-      core.MakeMigrations(ctx)
-    }
+  ctx.Db.Close()
 
-    case core.CLEAN: {
-      core.Clean(ctx)
-    }
+  if err != nil {
+    exitIfCancelled(ctx)
+    log.Fatalln(err)
+  }
+
+  if ctx.DryRun {
+    log.Println("Dry run, nothing was committed.")
   }
 
-  perr(ctx.DbTx.Commit())
+  if !passed {
+    log.Println("Failed.")
+    os.Exit(1)
+  }
 
   log.Println("Done.")
 }